@@ -3,6 +3,7 @@ package analyze
 import (
 	"fmt"
 	"log"
+	"sort"
 )
 
 type targetSet map[*Target]struct{}
@@ -29,6 +30,28 @@ func (u *unusedDep) HTML(g *Graph) string {
 		g.targetURL(u.Target), u.Dep)
 }
 
+// readWriteRace is a command reading a file before another command
+// finished writing it.
+type readWriteRace struct {
+	File   string
+	Reader *Command
+	Writer *Command
+
+	// Set if the commands ran at the same time; otherwise the
+	// reader finished before the writer was sent to a worker.
+	Overlap bool
+}
+
+func (r *readWriteRace) HTML(g *Graph) string {
+	what, when := "read before write", "later"
+	if r.Overlap {
+		what, when = "read/write race", "at the same time"
+	}
+	return fmt.Sprintf("%s: target %s (%s) reads %s, which target %s (%s) writes %s",
+		what, g.targetURL(g.Intern(r.Reader.Target)), commandRef(r.Reader), r.File,
+		g.targetURL(g.Intern(r.Writer.Target)), commandRef(r.Writer), when)
+}
+
 type checkTargetResult struct {
 	errors []Error
 	edges  map[edge]struct{}
@@ -124,6 +147,7 @@ func (g *Graph) checkTargets() {
 	for _, target := range g.TargetByName {
 		g.checkUnusedDeps(target)
 	}
+	g.checkRaces()
 	log.Println("done checking targets")
 }
 
@@ -136,3 +160,50 @@ func (g *Graph) checkUnusedDeps(target *Target) {
 		}
 	}
 }
+
+// checkRaces finds commands that were sent to a worker before the
+// command writing a file they read had finished. They may have read a stale or
+// partial file.
+func (g *Graph) checkRaces() {
+	var ids internedSlice
+	for id := range g.CommandByID {
+		ids = append(ids, id)
+	}
+	sort.Sort(ids)
+
+	// CommandByWrite only has the first writer of a file.
+	writers := map[string][]*Command{}
+	for _, id := range ids {
+		c := g.CommandByID[id]
+		for _, f := range c.Writes {
+			writers[f] = append(writers[f], c)
+		}
+	}
+
+	for _, id := range ids {
+		r := g.CommandByID[id]
+		if r.Time.IsZero() {
+			continue
+		}
+		for _, f := range r.Reads {
+			for _, w := range writers[f] {
+				if w == r || w.Time.IsZero() || !r.Sent().Before(w.Time) {
+					continue
+				}
+				e := &readWriteRace{
+					File:    f,
+					Reader:  r,
+					Writer:  w,
+					Overlap: w.Sent().Before(r.Time),
+				}
+				g.addError(e)
+				if r.target != nil {
+					r.target.Errors = append(r.target.Errors, e)
+				}
+				if w.target != nil && w.target != r.target {
+					w.target.Errors = append(w.target.Errors, e)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package analyze
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckRaces(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(end int) time.Time { return t0.Add(time.Duration(end) * time.Second) }
+	g := NewGraph([]*Command{
+		// Writes gen.h from 0s to 4s.
+		{Filename: "1", Target: "gen.h", Writes: []string{"gen.h"},
+			Time: at(4), Duration: 4 * time.Second},
+		// Reads it from 2s to 3s, while it is being written.
+		{Filename: "2", Target: "a.o", Reads: []string{"gen.h"}, Writes: []string{"a.o"},
+			Time: at(3), Duration: time.Second},
+		// Reads it from 5s to 6s; fine.
+		{Filename: "3", Target: "b.o", Reads: []string{"gen.h"}, Writes: []string{"b.o"},
+			Time: at(6), Duration: time.Second},
+		// Writes out.txt from 10s to 11s, after c.o read it.
+		{Filename: "4", Target: "out.txt", Writes: []string{"out.txt"},
+			Time: at(11), Duration: time.Second},
+		{Filename: "5", Target: "c.o", Reads: []string{"out.txt"}, Writes: []string{"c.o"},
+			Time: at(2), Duration: time.Second},
+	})
+
+	var races []*readWriteRace
+	for _, e := range g.Errors {
+		if r, ok := e.(*readWriteRace); ok {
+			races = append(races, r)
+		}
+	}
+	if len(races) != 2 {
+		t.Fatalf("got %d races, want 2", len(races))
+	}
+	if r := races[0]; r.Reader.Target != "a.o" || r.Writer.Target != "gen.h" || !r.Overlap {
+		t.Errorf("got %+v, want overlapping race between a.o and gen.h", r)
+	}
+	if r := races[1]; r.Reader.Target != "c.o" || r.Writer.Target != "out.txt" || r.Overlap {
+		t.Errorf("got %+v, want c.o reading out.txt before it is written", r)
+	}
+
+	if n := len(g.TargetByName[g.Lookup("gen.h")].Errors); n != 1 {
+		t.Errorf("got %d errors on the writing target, want 1", n)
+	}
+}
+
+func TestCheckRacesLaterWriter(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(end int) time.Time { return t0.Add(time.Duration(end) * time.Second) }
+	g := NewGraph([]*Command{
+		// Writes gen.h from 0s to 1s.
+		{Filename: "1", Target: "gen.h", Writes: []string{"gen.h"},
+			Time: at(1), Duration: time.Second},
+		// Writes it again from 3s to 5s.
+		{Filename: "2", Target: "regen", Writes: []string{"gen.h"},
+			Time: at(5), Duration: 2 * time.Second},
+		// Reads it from 2s to 4s, while the second write runs.
+		{Filename: "3", Target: "a.o", Reads: []string{"gen.h"}, Writes: []string{"a.o"},
+			Time: at(4), Duration: 2 * time.Second},
+	})
+
+	var races []*readWriteRace
+	for _, e := range g.Errors {
+		if r, ok := e.(*readWriteRace); ok {
+			races = append(races, r)
+		}
+	}
+	if len(races) != 1 {
+		t.Fatalf("got %d races, want 1", len(races))
+	}
+	if r := races[0]; r.Reader.Target != "a.o" || r.Writer.Target != "regen" || !r.Overlap {
+		t.Errorf("got %+v, want overlapping race between a.o and regen", r)
+	}
+}
+
+func TestCheckRacesQueued(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	at := func(end int) time.Time { return t0.Add(time.Duration(end) * time.Second) }
+	g := NewGraph([]*Command{
+		// Writes gen.h from 0s to 4s.
+		{Filename: "1", Target: "gen.h", Writes: []string{"gen.h"},
+			Time: at(4), Duration: 4 * time.Second},
+		// Waits for a worker from 2s, and is only sent at 5s.
+		{Filename: "2", Target: "a.o", Reads: []string{"gen.h"}, Writes: []string{"a.o"},
+			Time: at(6), Duration: 4 * time.Second,
+			Phases: []Phase{{Name: "send", Start: 3 * time.Second, Duration: 0}}},
+	})
+
+	for _, e := range g.Errors {
+		if r, ok := e.(*readWriteRace); ok {
+			t.Errorf("got race %+v for a command sent after the write", r)
+		}
+	}
+}
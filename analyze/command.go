@@ -12,15 +12,65 @@ type Command struct {
 	Reads     []string
 	Writes    []string
 	Deletions []string
-	Time      time.Time
-	Duration  time.Duration
-	Command   string
-	Filename  string
+	// Time is when the command finished.
+	Time     time.Time
+	Duration time.Duration
+	Command  string
+	Filename string
+
+	// The arguments of the command. Command joins them with
+	// spaces, so it can't be run again.
+	Argv []string
+
+	// Worker that ran the command, if any, and the phases of
+	// running it.
+	Worker string
+	Phases []Phase
+
+	// Hashes of the environment variables by name, and the files
+	// read as they were when the command started, to explain why
+	// it ran again. The values are not stored, as they may be
+	// secret.
+	EnvHashes map[string]string
+	Inputs    []Input
 
 	target *Target
 }
 
+// Input is a file read by a command.
+type Input struct {
+	Path    string
+	Hash    string
+	ModTime time.Time
+}
+
+// Phase is a step in running a command, eg. waiting for the worker,
+// or sending back the results.
+type Phase struct {
+	Name string
+	// Relative to the start of the command.
+	Start    time.Duration
+	Duration time.Duration
+}
+
 func (a *Command) ID() string {
 	_, base := filepath.Split(a.Filename)
 	return base
 }
+
+func (a *Command) Start() time.Time {
+	return a.Time.Add(-a.Duration)
+}
+
+// Sent returns when the command was sent to the worker that ran it.
+// Before that it waited for a slot, and didn't see any files. For
+// commands that didn't run on a worker, it is the start.
+func (a *Command) Sent() time.Time {
+	s := a.Start()
+	for _, p := range a.Phases {
+		if p.Name == "send" {
+			s = a.Start().Add(p.Start)
+		}
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package analyze
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// targetDeps returns the targets that target waits for: its declared
+// deps, and the targets writing files it reads.
+func (g *Graph) targetDeps(target *Target) []*Target {
+	seen := targetSet{}
+	var deps []*Target
+	add := func(t *Target) {
+		if t == nil || t == target {
+			return
+		}
+		if _, ok := seen[t]; ok {
+			return
+		}
+		seen[t] = yes
+		deps = append(deps, t)
+	}
+	for _, d := range internKeys(target.Deps) {
+		add(g.TargetByName[d])
+	}
+	for _, r := range internKeys(target.Reads) {
+		add(g.TargetByWrite[r])
+	}
+	return deps
+}
+
+// topoSort returns the targets with dependencies before the targets
+// that need them. Edges closing a cycle are dropped.
+func (g *Graph) topoSort(deps map[*Target][]*Target) []*Target {
+	var order []*Target
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := map[*Target]int{}
+	var visit func(t *Target)
+	visit = func(t *Target) {
+		state[t] = visiting
+		for _, d := range deps[t] {
+			switch state[d] {
+			case visiting:
+				log.Println("cyclic dep", t.Name, d.Name)
+			case 0:
+				visit(d)
+			}
+		}
+		state[t] = done
+		order = append(order, t)
+	}
+
+	var names internedSlice
+	for n := range g.TargetByName {
+		names = append(names, n)
+	}
+	sort.Sort(names)
+	for _, n := range names {
+		if t := g.TargetByName[n]; state[t] == 0 {
+			visit(t)
+		}
+	}
+	return order
+}
+
+// computeCriticalPath computes, assuming unlimited parallelism, the
+// earliest finish and slack of each target, and the chain of targets
+// that determines the length of the build.
+func (g *Graph) computeCriticalPath() {
+	deps := map[*Target][]*Target{}
+	for _, t := range g.TargetByName {
+		deps[t] = g.targetDeps(t)
+	}
+	order := g.topoSort(deps)
+
+	// Dependencies in a cycle are not done before their
+	// dependents, and are ignored.
+	index := map[*Target]int{}
+	for i, t := range order {
+		index[t] = i
+	}
+	for t, ds := range deps {
+		var acyclic []*Target
+		for _, d := range ds {
+			if index[d] < index[t] {
+				acyclic = append(acyclic, d)
+			}
+		}
+		deps[t] = acyclic
+	}
+
+	var length time.Duration
+	for _, t := range order {
+		t.EarliestFinish = 0
+		for _, d := range deps[t] {
+			if d.EarliestFinish > t.EarliestFinish {
+				t.EarliestFinish = d.EarliestFinish
+			}
+		}
+		t.EarliestFinish += t.Duration
+		if t.EarliestFinish > length {
+			length = t.EarliestFinish
+		}
+	}
+
+	latest := map[*Target]time.Duration{}
+	for _, t := range order {
+		latest[t] = length
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		t := order[i]
+		t.Slack = latest[t] - t.EarliestFinish
+		for _, d := range deps[t] {
+			if start := latest[t] - t.Duration; start < latest[d] {
+				latest[d] = start
+			}
+		}
+	}
+
+	g.CriticalPath = nil
+	var last *Target
+	for _, t := range order {
+		if last == nil || t.EarliestFinish > last.EarliestFinish {
+			last = t
+		}
+	}
+	for t := last; t != nil; {
+		g.CriticalPath = append([]*Target{t}, g.CriticalPath...)
+		var next *Target
+		for _, d := range deps[t] {
+			if next == nil || d.EarliestFinish > next.EarliestFinish {
+				next = d
+			}
+		}
+		t = next
+	}
+}
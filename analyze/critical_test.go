@@ -0,0 +1,36 @@
+package analyze
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCriticalPath(t *testing.T) {
+	g := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Writes: []string{"a.o"}, Duration: 2 * time.Second},
+		{Filename: "2", Target: "b.o", Writes: []string{"b.o"}, Duration: 5 * time.Second},
+		{Filename: "3", Target: "prog", Writes: []string{"prog"}, Duration: time.Second,
+			Deps: []string{"a.o", "b.o"}, Reads: []string{"a.o", "b.o"}},
+	})
+
+	var path []string
+	for _, t := range g.CriticalPath {
+		path = append(path, t.Name.String())
+	}
+	if len(path) != 2 || path[0] != "b.o" || path[1] != "prog" {
+		t.Errorf("got critical path %v, want [b.o prog]", path)
+	}
+
+	target := func(n string) *Target {
+		return g.TargetByName[g.Lookup(n)]
+	}
+	if s := target("a.o").Slack; s != 3*time.Second {
+		t.Errorf("a.o: got slack %v, want 3s", s)
+	}
+	if s := target("b.o").Slack; s != 0 {
+		t.Errorf("b.o: got slack %v, want 0", s)
+	}
+	if f := target("prog").EarliestFinish; f != 6*time.Second {
+		t.Errorf("prog: got finish %v, want 6s", f)
+	}
+}
@@ -0,0 +1,385 @@
+package analyze
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// TargetDiff describes how a target changed between two builds.
+type TargetDiff struct {
+	Name string
+
+	// Nil if the target only exists in the other build.
+	Old, New *Target
+
+	AddedReads, RemovedReads   []string
+	AddedWrites, RemovedWrites []string
+
+	// Command lines that changed.
+	AddedCommands, RemovedCommands []string
+
+	// Names of environment variables that were set, unset or
+	// changed.
+	AddedEnv, RemovedEnv, ChangedEnv []string
+
+	// Files read in both builds whose content changed, or only
+	// their modification time.
+	ContentChanged, MtimeChanged []string
+}
+
+// Reasons explains why a target that ran in both builds ran again.
+func (d *TargetDiff) Reasons() []string {
+	var r []string
+	for _, c := range d.AddedCommands {
+		r = append(r, "command changed to: "+c)
+	}
+	for _, c := range d.RemovedCommands {
+		r = append(r, "command dropped: "+c)
+	}
+	for _, e := range d.AddedEnv {
+		r = append(r, "environment added: "+e)
+	}
+	for _, e := range d.RemovedEnv {
+		r = append(r, "environment removed: "+e)
+	}
+	for _, e := range d.ChangedEnv {
+		r = append(r, "environment changed: "+e)
+	}
+	for _, f := range d.ContentChanged {
+		r = append(r, "content changed: "+f)
+	}
+	for _, f := range d.AddedReads {
+		r = append(r, "new input: "+f)
+	}
+	for _, f := range d.RemovedReads {
+		r = append(r, "input dropped: "+f)
+	}
+	for _, f := range d.MtimeChanged {
+		r = append(r, "only mtime changed: "+f)
+	}
+	if len(r) == 0 {
+		r = append(r, "no change recorded")
+	}
+	return r
+}
+
+// Slowdown returns how much longer the target took in the new build.
+func (d *TargetDiff) Slowdown() time.Duration {
+	if d.Old == nil || d.New == nil {
+		return 0
+	}
+	return d.New.Duration - d.Old.Duration
+}
+
+func (d *TargetDiff) changed() bool {
+	return d.Old == nil || d.New == nil ||
+		len(d.AddedReads)+len(d.RemovedReads)+len(d.AddedWrites)+len(d.RemovedWrites)+
+			len(d.AddedCommands)+len(d.RemovedCommands) > 0
+}
+
+// Diff describes the differences between two builds.
+type Diff struct {
+	// By target name.
+	Targets []*TargetDiff
+}
+
+func stringSet(m map[*String]struct{}) map[string]struct{} {
+	r := map[string]struct{}{}
+	for k := range m {
+		r[k.String()] = yes
+	}
+	return r
+}
+
+// envHashes returns the hashes of the environment variables of the
+// commands of the target, as of the last command setting them.
+func envHashes(t *Target) map[string]string {
+	r := map[string]string{}
+	if t != nil {
+		for _, c := range t.Commands {
+			for k, v := range c.EnvHashes {
+				r[k] = v
+			}
+		}
+	}
+	return r
+}
+
+// inputs returns the files read by the commands of the target, as of
+// the last command reading them.
+func inputs(t *Target) map[string]Input {
+	r := map[string]Input{}
+	for _, c := range t.Commands {
+		for _, in := range c.Inputs {
+			r[in.Path] = in
+		}
+	}
+	return r
+}
+
+func commandSet(t *Target) map[string]struct{} {
+	r := map[string]struct{}{}
+	if t != nil {
+		for _, c := range t.Commands {
+			r[c.Command] = yes
+		}
+	}
+	return r
+}
+
+// setDiff returns the sorted entries of a that are not in b.
+func setDiff(a, b map[string]struct{}) []string {
+	var r []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			r = append(r, k)
+		}
+	}
+	sort.Strings(r)
+	return r
+}
+
+func newTargetDiff(name string, old, new *Target) *TargetDiff {
+	d := &TargetDiff{Name: name, Old: old, New: new}
+	oldReads, newReads := map[string]struct{}{}, map[string]struct{}{}
+	oldWrites, newWrites := map[string]struct{}{}, map[string]struct{}{}
+	if old != nil {
+		oldReads, oldWrites = stringSet(old.Reads), stringSet(old.Writes)
+	}
+	if new != nil {
+		newReads, newWrites = stringSet(new.Reads), stringSet(new.Writes)
+	}
+	d.AddedReads, d.RemovedReads = setDiff(newReads, oldReads), setDiff(oldReads, newReads)
+	d.AddedWrites, d.RemovedWrites = setDiff(newWrites, oldWrites), setDiff(oldWrites, newWrites)
+	oldCmds, newCmds := commandSet(old), commandSet(new)
+	d.AddedCommands, d.RemovedCommands = setDiff(newCmds, oldCmds), setDiff(oldCmds, newCmds)
+	oldEnv, newEnv := envHashes(old), envHashes(new)
+	for k, v := range newEnv {
+		if prev, ok := oldEnv[k]; !ok {
+			d.AddedEnv = append(d.AddedEnv, k)
+		} else if prev != v {
+			d.ChangedEnv = append(d.ChangedEnv, k)
+		}
+	}
+	for k := range oldEnv {
+		if _, ok := newEnv[k]; !ok {
+			d.RemovedEnv = append(d.RemovedEnv, k)
+		}
+	}
+	sort.Strings(d.AddedEnv)
+	sort.Strings(d.RemovedEnv)
+	sort.Strings(d.ChangedEnv)
+
+	if old != nil && new != nil {
+		oldInputs := inputs(old)
+		for p, in := range inputs(new) {
+			prev, ok := oldInputs[p]
+			switch {
+			case !ok:
+			case prev.Hash != in.Hash:
+				d.ContentChanged = append(d.ContentChanged, p)
+			case !prev.ModTime.Equal(in.ModTime):
+				d.MtimeChanged = append(d.MtimeChanged, p)
+			}
+		}
+		sort.Strings(d.ContentChanged)
+		sort.Strings(d.MtimeChanged)
+	}
+	return d
+}
+
+// NewDiff compares the targets of two builds.
+func NewDiff(old, new *Graph) *Diff {
+	names := map[string]struct{}{}
+	for n := range old.TargetByName {
+		names[n.String()] = yes
+	}
+	for n := range new.TargetByName {
+		names[n.String()] = yes
+	}
+
+	d := &Diff{}
+	for _, n := range keys(names) {
+		var o, t *Target
+		if s := old.Lookup(n); s != nil {
+			o = old.TargetByName[s]
+		}
+		if s := new.Lookup(n); s != nil {
+			t = new.TargetByName[s]
+		}
+		d.Targets = append(d.Targets, newTargetDiff(n, o, t))
+	}
+	return d
+}
+
+// Rebuilt returns the targets that ran in both builds.
+func (d *Diff) Rebuilt() []*TargetDiff {
+	var r []*TargetDiff
+	for _, t := range d.Targets {
+		if t.Old != nil && t.New != nil {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+// Target returns the comparison for the named target, or nil.
+func (d *Diff) Target(name string) *TargetDiff {
+	i := sort.Search(len(d.Targets), func(i int) bool { return d.Targets[i].Name >= name })
+	if i < len(d.Targets) && d.Targets[i].Name == name {
+		return d.Targets[i]
+	}
+	return nil
+}
+
+// WriteReasons writes why each target that ran in both builds ran
+// again.
+func (d *Diff) WriteReasons(w io.Writer) {
+	for _, t := range d.Rebuilt() {
+		fmt.Fprintf(w, "%s:\n", t.Name)
+		for _, r := range t.Reasons() {
+			fmt.Fprintf(w, "  %s\n", r)
+		}
+	}
+}
+
+// Changed returns the targets that were added, removed, or whose
+// reads, writes or commands changed.
+func (d *Diff) Changed() []*TargetDiff {
+	var r []*TargetDiff
+	for _, t := range d.Targets {
+		if t.changed() {
+			r = append(r, t)
+		}
+	}
+	return r
+}
+
+type bySlowdown []*TargetDiff
+
+func (s bySlowdown) Len() int {
+	return len(s)
+}
+
+func (s bySlowdown) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s bySlowdown) Less(i, j int) bool {
+	return s[i].Slowdown() > s[j].Slowdown()
+}
+
+// Regressions returns the targets that got slower by at least min,
+// slowest first.
+func (d *Diff) Regressions(min time.Duration) []*TargetDiff {
+	var r []*TargetDiff
+	for _, t := range d.Targets {
+		if t.Slowdown() > 0 && t.Slowdown() >= min {
+			r = append(r, t)
+		}
+	}
+	sort.Sort(bySlowdown(r))
+	return r
+}
+
+func writeList(w io.Writer, title string, l []string) {
+	if len(l) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "  %s:\n", title)
+	for _, e := range l {
+		fmt.Fprintf(w, "    %s\n", e)
+	}
+}
+
+// WriteText writes the changed targets, and the targets that took
+// at least min longer.
+func (d *Diff) WriteText(w io.Writer, min time.Duration) {
+	for _, t := range d.Changed() {
+		switch {
+		case t.Old == nil:
+			fmt.Fprintf(w, "added target %s\n", t.Name)
+		case t.New == nil:
+			fmt.Fprintf(w, "removed target %s\n", t.Name)
+		default:
+			fmt.Fprintf(w, "changed target %s\n", t.Name)
+		}
+		writeList(w, "added commands", t.AddedCommands)
+		writeList(w, "removed commands", t.RemovedCommands)
+		writeList(w, "added reads", t.AddedReads)
+		writeList(w, "removed reads", t.RemovedReads)
+		writeList(w, "added writes", t.AddedWrites)
+		writeList(w, "removed writes", t.RemovedWrites)
+	}
+
+	for _, t := range d.Regressions(min) {
+		fmt.Fprintf(w, "slower target %s: %s -> %s (+%s)\n",
+			t.Name, t.Old.Duration, t.New.Duration, t.Slowdown())
+	}
+}
+
+func writeHTMLList(w io.Writer, title string, l []string) {
+	if len(l) == 0 {
+		return
+	}
+	if title != "" {
+		fmt.Fprintf(w, "<p>%s</p>", title)
+	}
+	fmt.Fprintf(w, "<ul>\n")
+	for _, e := range l {
+		fmt.Fprintf(w, "<li><tt>%s</tt>\n", html.EscapeString(e))
+	}
+	fmt.Fprintf(w, "</ul>\n")
+}
+
+func (g *Graph) ServeDiff(w http.ResponseWriter, req *http.Request) {
+	if g.Diff == nil {
+		http.Error(w, "404 no baseline build loaded", http.StatusNotFound)
+		return
+	}
+	min := time.Duration(0)
+	if v := req.URL.Query().Get("min"); v != "" {
+		var err error
+		if min, err = time.ParseDuration(v); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fmt.Fprintf(w, "<html><body>\n")
+	fmt.Fprintf(w, "<h2>changed targets</h2>\n")
+	for _, t := range g.Diff.Changed() {
+		what := "changed"
+		if t.Old == nil {
+			what = "added"
+		} else if t.New == nil {
+			what = "removed"
+		}
+		fmt.Fprintf(w, "<h3>%s %s</h3>\n", what, g.targetURL(g.Intern(t.Name)))
+		writeHTMLList(w, "added commands", t.AddedCommands)
+		writeHTMLList(w, "removed commands", t.RemovedCommands)
+		writeHTMLList(w, "added reads", t.AddedReads)
+		writeHTMLList(w, "removed reads", t.RemovedReads)
+		writeHTMLList(w, "added writes", t.AddedWrites)
+		writeHTMLList(w, "removed writes", t.RemovedWrites)
+	}
+
+	fmt.Fprintf(w, "<h2>why targets ran again</h2><ul>\n")
+	for _, t := range g.Diff.Rebuilt() {
+		fmt.Fprintf(w, "<li>%s", g.targetURL(g.Intern(t.Name)))
+		writeHTMLList(w, "", t.Reasons())
+	}
+	fmt.Fprintf(w, "</ul>\n")
+
+	fmt.Fprintf(w, "<h2>slower targets</h2>\n")
+	fmt.Fprintf(w, "<table><tr><th>target</th><th>before</th><th>after</th><th>slowdown</th></tr>\n")
+	for _, t := range g.Diff.Regressions(min) {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			g.targetURL(g.Intern(t.Name)), t.Old.Duration, t.New.Duration, t.Slowdown())
+	}
+	fmt.Fprintf(w, "</table></body></html>\n")
+}
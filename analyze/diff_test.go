@@ -0,0 +1,105 @@
+package analyze
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	old := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -c a.c", Reads: []string{"a.c"},
+			Writes: []string{"a.o"}, Duration: time.Second},
+		{Filename: "2", Target: "b.o", Command: "cc -c b.c", Reads: []string{"b.c"},
+			Writes: []string{"b.o"}, Duration: time.Second},
+	})
+	new := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -O2 -c a.c", Reads: []string{"a.c", "a.h"},
+			Writes: []string{"a.o"}, Duration: 3 * time.Second},
+		{Filename: "3", Target: "c.o", Command: "cc -c c.c", Reads: []string{"c.c"},
+			Writes: []string{"c.o"}, Duration: time.Second},
+	})
+
+	d := NewDiff(old, new)
+	changed := d.Changed()
+	if len(changed) != 3 {
+		t.Fatalf("got %d changed targets, want 3", len(changed))
+	}
+	a := changed[0]
+	if a.Name != "a.o" || !reflect.DeepEqual(a.AddedReads, []string{"a.h"}) ||
+		!reflect.DeepEqual(a.AddedCommands, []string{"cc -O2 -c a.c"}) ||
+		!reflect.DeepEqual(a.RemovedCommands, []string{"cc -c a.c"}) {
+		t.Errorf("got %+v", a)
+	}
+	if changed[1].Name != "b.o" || changed[1].New != nil {
+		t.Errorf("b.o should be removed: %+v", changed[1])
+	}
+	if changed[2].Name != "c.o" || changed[2].Old != nil {
+		t.Errorf("c.o should be added: %+v", changed[2])
+	}
+
+	if r := d.Regressions(time.Second); len(r) != 1 || r[0].Name != "a.o" || r[0].Slowdown() != 2*time.Second {
+		t.Errorf("got regressions %v", r)
+	}
+
+	buf := &bytes.Buffer{}
+	d.WriteText(buf, time.Second)
+	for _, want := range []string{"changed target a.o", "removed target b.o", "added target c.o", "slower target a.o"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("text report misses %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestDiffReasons(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	t1 := time.Unix(2000, 0)
+	old := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -c a.c", EnvHashes: map[string]string{"CC": "gcc", "CFLAGS": "-O2"},
+			Reads: []string{"a.c", "a.h", "b.h"}, Writes: []string{"a.o"},
+			Inputs: []Input{{"a.c", "h1", t0}, {"a.h", "h2", t0}, {"b.h", "h3", t0}}},
+	})
+	new := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -c a.c", EnvHashes: map[string]string{"CC": "clang", "LANG": "C"},
+			Reads: []string{"a.c", "a.h", "b.h"}, Writes: []string{"a.o"},
+			Inputs: []Input{{"a.c", "h1", t0}, {"a.h", "h2new", t1}, {"b.h", "h3", t1}}},
+	})
+
+	d := NewDiff(old, new).Target("a.o")
+	if d == nil {
+		t.Fatal("a.o missing from diff")
+	}
+	want := []string{
+		"environment added: LANG",
+		"environment removed: CFLAGS",
+		"environment changed: CC",
+		"content changed: a.h",
+		"only mtime changed: b.h",
+	}
+	if got := d.Reasons(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDiffReasonsDropped(t *testing.T) {
+	old := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -c a.c",
+			Reads: []string{"a.c", "a.h"}, Writes: []string{"a.o"}},
+		{Filename: "2", Target: "a.o", Command: "strip a.o",
+			Writes: []string{"a.o"}},
+	})
+	new := NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Command: "cc -c a.c",
+			Reads: []string{"a.c"}, Writes: []string{"a.o"}},
+	})
+
+	want := []string{
+		"command dropped: strip a.o",
+		"input dropped: a.h",
+	}
+	if got := NewDiff(old, new).Target("a.o").Reasons(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
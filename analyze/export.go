@@ -0,0 +1,319 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// observedEdge is a dependency found from the reads of a target.
+type observedEdge struct {
+	target, dep *Target
+	file        *String
+	declared    bool
+}
+
+type byEdgeName []observedEdge
+
+func (s byEdgeName) Len() int {
+	return len(s)
+}
+
+func (s byEdgeName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byEdgeName) Less(i, j int) bool {
+	a, b := s[i], s[j]
+	if a.target.Name != b.target.Name {
+		return a.target.Name.String() < b.target.Name.String()
+	}
+	return a.dep.Name.String() < b.dep.Name.String()
+}
+
+// observedEdges returns an edge for each target that reads a file
+// written by another target, sorted by name.
+func (g *Graph) observedEdges() []observedEdge {
+	var edges []observedEdge
+	for _, t := range g.TargetByName {
+		seen := targetSet{}
+		for _, r := range internKeys(t.Reads) {
+			d := g.TargetByWrite[r]
+			if d == nil || d == t {
+				continue
+			}
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = yes
+			_, declared := t.Deps[d.Name]
+			if _, ok := t.Deps[r]; ok {
+				declared = true
+			}
+			edges = append(edges, observedEdge{t, d, r, declared})
+		}
+	}
+	sort.Sort(byEdgeName(edges))
+	return edges
+}
+
+func (g *Graph) sortedTargets() []*Target {
+	var names internedSlice
+	for n := range g.TargetByName {
+		names = append(names, n)
+	}
+	sort.Sort(names)
+	var ts []*Target
+	for _, n := range names {
+		ts = append(ts, g.TargetByName[n])
+	}
+	return ts
+}
+
+// WriteDot writes the observed dependencies between targets as a
+// Graphviz graph. Undeclared dependencies are dashed.
+func (g *Graph) WriteDot(w io.Writer) error {
+	fmt.Fprintf(w, "digraph build {\n")
+	for _, t := range g.sortedTargets() {
+		fmt.Fprintf(w, "  %q [tooltip=%q];\n", t.Name.String(), t.Duration.String())
+	}
+	for _, e := range g.observedEdges() {
+		attrs := ""
+		if !e.declared {
+			attrs = " [style=dashed, color=red]"
+		}
+		fmt.Fprintf(w, "  %q -> %q%s;\n", e.target.Name.String(), e.dep.Name.String(), attrs)
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+type jsonCommand struct {
+	ID       string
+	Target   string
+	Dir      string
+	Command  string
+	Worker   string
+	Time     time.Time
+	Duration time.Duration
+}
+
+type jsonTarget struct {
+	Name     string
+	Deps     []string
+	Reads    []string
+	Writes   []string
+	Commands []string
+	Duration time.Duration
+	Errors   []string
+}
+
+type jsonEdge struct {
+	Target   string
+	Dep      string
+	File     string
+	Declared bool
+}
+
+type jsonGraph struct {
+	Targets  []jsonTarget
+	Commands []jsonCommand
+	Edges    []jsonEdge
+	Errors   []string
+}
+
+var tagRe = regexp.MustCompile("<[^>]*>")
+
+// errorText returns the error without markup.
+func (g *Graph) errorText(e Error) string {
+	return strings.TrimSpace(html.UnescapeString(tagRe.ReplaceAllString(e.HTML(g), "")))
+}
+
+func stringKeys(m map[*String]struct{}) []string {
+	r := []string{}
+	for _, k := range internKeys(m) {
+		r = append(r, k.String())
+	}
+	return r
+}
+
+// WriteJSON writes the targets, commands, observed dependencies and
+// errors of the graph as JSON.
+func (g *Graph) WriteJSON(w io.Writer) error {
+	out := jsonGraph{
+		Targets:  []jsonTarget{},
+		Commands: []jsonCommand{},
+		Edges:    []jsonEdge{},
+		Errors:   []string{},
+	}
+	for _, t := range g.sortedTargets() {
+		jt := jsonTarget{
+			Name:     t.Name.String(),
+			Deps:     stringKeys(t.Deps),
+			Reads:    stringKeys(t.Reads),
+			Writes:   stringKeys(t.Writes),
+			Commands: []string{},
+			Duration: t.Duration,
+			Errors:   []string{},
+		}
+		for _, c := range t.Commands {
+			jt.Commands = append(jt.Commands, c.ID())
+			out.Commands = append(out.Commands, jsonCommand{
+				ID:       c.ID(),
+				Target:   jt.Name,
+				Dir:      c.Dir,
+				Command:  c.Command,
+				Worker:   c.Worker,
+				Time:     c.Time,
+				Duration: c.Duration,
+			})
+		}
+		for _, e := range t.Errors {
+			jt.Errors = append(jt.Errors, g.errorText(e))
+		}
+		out.Targets = append(out.Targets, jt)
+	}
+	for _, e := range g.observedEdges() {
+		out.Edges = append(out.Edges, jsonEdge{
+			Target:   e.target.Name.String(),
+			Dep:      e.dep.Name.String(),
+			File:     e.file.String(),
+			Declared: e.declared,
+		})
+	}
+	for _, e := range g.Errors {
+		out.Errors = append(out.Errors, g.errorText(e))
+	}
+
+	data, err := json.MarshalIndent(&out, "", " ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+var shellSafeRe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes s for the shell, if needed.
+func shellQuote(s string) string {
+	if shellSafeRe.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// shellCommand returns a shell command line that runs c in its
+// directory.
+func shellCommand(c *Command) string {
+	// Older dumps only have the joined command line, which is
+	// the best we can do.
+	line := c.Command
+	if len(c.Argv) > 0 {
+		var args []string
+		for _, a := range c.Argv {
+			args = append(args, shellQuote(a))
+		}
+		line = strings.Join(args, " ")
+	}
+	return fmt.Sprintf("(cd %s && %s)", shellQuote(c.Dir), line)
+}
+
+var ninjaPathEscaper = strings.NewReplacer("$", "$$", " ", "$ ", ":", "$:")
+
+// Ninja has no escape for newlines: "$\n" continues the line.
+var ninjaEscaper = strings.NewReplacer("$", "$$", "\n", " ")
+
+func ninjaPaths(ps []string) string {
+	var r []string
+	for _, p := range ps {
+		r = append(r, ninjaPathEscaper.Replace(p))
+	}
+	return strings.Join(r, " ")
+}
+
+// WriteNinja writes a Ninja build file that runs the commands of the
+// build, with the files they read and wrote as inputs and outputs.
+// Paths are relative to the top of the build. A file written by
+// several targets is only an output of the first, and reads closing
+// a cycle are dropped; Ninja allows neither. Commands with a newline
+// in them can't be expressed, and fail instead.
+func (g *Graph) WriteNinja(out io.Writer) error {
+	w := &bytes.Buffer{}
+	fmt.Fprintf(w, "# Generated by termite analyze from an observed build.\n\n")
+	fmt.Fprintf(w, "rule run\n  command = $cmd\n  description = $desc\n\n")
+	targets := g.sortedTargets()
+	owner := map[string]*Target{}
+	for _, t := range targets {
+		for _, f := range stringKeys(t.Writes) {
+			if owner[f] == nil {
+				owner[f] = t
+			}
+		}
+	}
+	deps := map[*Target][]*Target{}
+	for _, t := range targets {
+		for _, r := range stringKeys(t.Reads) {
+			if o := owner[r]; o != nil && o != t {
+				deps[t] = append(deps[t], o)
+			}
+		}
+	}
+	index := map[*Target]int{}
+	for i, t := range g.topoSort(deps) {
+		index[t] = i
+	}
+
+	for _, t := range targets {
+		var outs []string
+		for _, f := range stringKeys(t.Writes) {
+			if o := owner[f]; o != t {
+				fmt.Fprintf(w, "# %s also writes %s, which is an output of %s\n",
+					t.Name.String(), f, o.Name.String())
+				continue
+			}
+			outs = append(outs, f)
+		}
+		if len(outs) == 0 {
+			outs = []string{t.Name.String()}
+		}
+		var ins []string
+		for _, r := range stringKeys(t.Reads) {
+			if _, ok := t.Writes[g.Lookup(r)]; ok {
+				continue
+			}
+			if o := owner[r]; o != nil && o != t && index[o] > index[t] {
+				fmt.Fprintf(w, "# %s reads %s, an output of %s; dropped to break a cycle\n",
+					t.Name.String(), r, o.Name.String())
+				continue
+			}
+			ins = append(ins, r)
+		}
+		var cmds []string
+		for _, c := range t.Commands {
+			cmds = append(cmds, shellCommand(c))
+		}
+		cmd := strings.Join(cmds, " && ")
+		if strings.Contains(cmd, "\n") {
+			fmt.Fprintf(w, "# %s has a command with a newline, which Ninja can't run\n", t.Name.String())
+			cmd = fmt.Sprintf("echo %s >&2 && false",
+				shellQuote(ninjaEscaper.Replace(t.Name.String())+": command has a newline"))
+		}
+
+		fmt.Fprintf(w, "build %s: run", ninjaPaths(outs))
+		if len(ins) > 0 {
+			fmt.Fprintf(w, " %s", ninjaPaths(ins))
+		}
+		fmt.Fprintf(w, "\n  cmd = %s\n  desc = %s\n\n",
+			ninjaEscaper.Replace(cmd),
+			ninjaEscaper.Replace(t.Name.String()))
+	}
+	_, err := out.Write(w.Bytes())
+	return err
+}
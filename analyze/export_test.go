@@ -0,0 +1,133 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func exportTestGraph() *Graph {
+	return NewGraph([]*Command{
+		{Filename: "1", Target: "a.o", Dir: "/src", Command: "cc -c a.c",
+			Argv:  []string{"cc", "-c", "a.c"},
+			Reads: []string{"a.c"}, Writes: []string{"a.o"}},
+		{Filename: "2", Target: "prog", Dir: "/src", Command: "cc -o prog a.o",
+			Argv:  []string{"cc", "-o", "prog", "a.o"},
+			Reads: []string{"a.o"}, Writes: []string{"prog"}},
+	})
+}
+
+func TestWriteDot(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := exportTestGraph().WriteDot(buf); err != nil {
+		t.Fatalf("WriteDot: %v", err)
+	}
+	if want := `"prog" -> "a.o" [style=dashed, color=red];`; !strings.Contains(buf.String(), want) {
+		t.Errorf("got %s, want undeclared edge %s", buf.String(), want)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := exportTestGraph().WriteJSON(buf); err != nil {
+		t.Fatalf("WriteJSON: %v", err)
+	}
+	var got jsonGraph
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(got.Targets) != 2 || len(got.Commands) != 2 {
+		t.Errorf("got %d targets, %d commands, want 2, 2", len(got.Targets), len(got.Commands))
+	}
+	if len(got.Edges) != 1 || got.Edges[0].Target != "prog" || got.Edges[0].Dep != "a.o" || got.Edges[0].Declared {
+		t.Errorf("got edges %v", got.Edges)
+	}
+	if len(got.Errors) != 1 || !strings.Contains(got.Errors[0], "undeclared dependency") {
+		t.Errorf("got errors %v", got.Errors)
+	}
+}
+
+func TestWriteNinja(t *testing.T) {
+	buf := &bytes.Buffer{}
+	if err := exportTestGraph().WriteNinja(buf); err != nil {
+		t.Fatalf("WriteNinja: %v", err)
+	}
+	for _, want := range []string{
+		"build a.o: run a.c\n  cmd = (cd /src && cc -c a.c)\n",
+		"build prog: run a.o\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got %s, want %q", buf.String(), want)
+		}
+	}
+}
+
+func TestWriteNinjaQuoting(t *testing.T) {
+	g := NewGraph([]*Command{
+		{Filename: "1", Target: "gen", Dir: "/my src", Command: "sh -c echo 'x' > gen.h && touch log",
+			Argv:   []string{"sh", "-c", "echo 'x' > gen.h && touch log"},
+			Writes: []string{"gen.h", "log"}},
+		{Filename: "2", Target: "other", Dir: "/my src", Command: "touch log",
+			Argv:   []string{"touch", "log"},
+			Writes: []string{"log"}},
+	})
+	buf := &bytes.Buffer{}
+	if err := g.WriteNinja(buf); err != nil {
+		t.Fatalf("WriteNinja: %v", err)
+	}
+	for _, want := range []string{
+		`cmd = (cd '/my src' && sh -c 'echo '\''x'\'' > gen.h && touch log')`,
+		"build gen.h log: run\n",
+		"# other also writes log, which is an output of gen\n",
+		"build other: run\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("got %s, want %q", buf.String(), want)
+		}
+	}
+}
+
+func TestWriteNinjaCycle(t *testing.T) {
+	// Each of a and b reads what the other writes.
+	g := NewGraph([]*Command{
+		{Filename: "1", Target: "a", Dir: "/src", Command: "touch a",
+			Reads: []string{"b.out"}, Writes: []string{"a.out"}},
+		{Filename: "2", Target: "b", Dir: "/src", Command: "touch b",
+			Reads: []string{"a.out"}, Writes: []string{"b.out"}},
+	})
+	buf := &bytes.Buffer{}
+	if err := g.WriteNinja(buf); err != nil {
+		t.Fatalf("WriteNinja: %v", err)
+	}
+	got := buf.String()
+	if strings.Contains(got, "build a.out: run b.out") == strings.Contains(got, "build b.out: run a.out") {
+		t.Errorf("want exactly one edge of the cycle, got %s", got)
+	}
+	if !strings.Contains(got, "dropped to break a cycle") {
+		t.Errorf("dropped edge not noted: %s", got)
+	}
+}
+
+func TestWriteNinjaNewline(t *testing.T) {
+	g := NewGraph([]*Command{
+		{Filename: "1", Target: "gen", Dir: "/src", Command: "printf a\nb",
+			Argv: []string{"printf", "a\nb"}, Writes: []string{"gen.h"}},
+	})
+	buf := &bytes.Buffer{}
+	if err := g.WriteNinja(buf); err != nil {
+		t.Fatalf("WriteNinja: %v", err)
+	}
+	got := buf.String()
+	if strings.Contains(got, "printf") || strings.Contains(got, "$\n") {
+		t.Errorf("command with newline written: %s", got)
+	}
+	for _, want := range []string{
+		"# gen has a command with a newline, which Ninja can't run\n",
+		"cmd = echo 'gen: command has a newline' >&2 && false\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got %s, want %q", got, want)
+		}
+	}
+}
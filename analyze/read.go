@@ -26,6 +26,12 @@ type Graph struct {
 	CommandByWrite map[string]*Command
 	Errors         []Error
 	UsedEdges      map[edge]struct{}
+
+	// The chain of targets that took longest, first target first.
+	CriticalPath []*Target
+
+	// Comparison against an earlier build, if any.
+	Diff *Diff
 }
 
 type Target struct {
@@ -36,6 +42,12 @@ type Target struct {
 	Duration time.Duration
 	Commands []*Command
 	Errors   []Error
+
+	// When the target could finish if everything ran in
+	// parallel, and how much later it could finish without
+	// delaying the build.
+	EarliestFinish time.Duration
+	Slack          time.Duration
 }
 
 func (g *Graph) Lookup(s string) *String {
@@ -292,5 +304,6 @@ func NewGraph(anns []*Command) *Graph {
 		g.computeTarget(a)
 	}
 	g.checkTargets()
+	g.computeCriticalPath()
 	return &g
 }
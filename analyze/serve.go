@@ -87,7 +87,18 @@ func (g *Graph) writeNode(w http.ResponseWriter, a *Target) {
 	}
 	fmt.Fprintf(w, "</ul>\n")
 
+	if g.Diff != nil {
+		if d := g.Diff.Target(a.Name.String()); d != nil && d.Old != nil {
+			writeHTMLList(w, "why it ran again", d.Reasons())
+		}
+	}
+
 	fmt.Fprintf(w, "<p>timing: %s</p>\n", a.Duration)
+	fmt.Fprintf(w, "<p>earliest finish: %s, slack: %s", a.EarliestFinish, a.Slack)
+	if a.Slack == 0 {
+		fmt.Fprintf(w, " (on the <a href=\"/\">critical path</a>)")
+	}
+	fmt.Fprintf(w, "</p>\n")
 	fmt.Fprintf(w, "</body></html>\n")
 }
 
@@ -177,7 +188,19 @@ func (g *Graph) ServeRoot(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(w, "<ul>\n")
 	fmt.Fprintf(w, "<li><a href=\"/targets\">targets</a>")
 	fmt.Fprintf(w, "<li><a href=\"/errors\">errors</a>")
-	fmt.Fprintf(w, "</ul></body></html>\n")
+	fmt.Fprintf(w, "<li><a href=\"/trace.json\">trace</a> (for chrome://tracing or ui.perfetto.dev)")
+	if g.Diff != nil {
+		fmt.Fprintf(w, "<li><a href=\"/diff\">changes from the earlier build</a>")
+	}
+	fmt.Fprintf(w, "</ul>\n")
+
+	fmt.Fprintf(w, "<p>critical path</p>\n")
+	fmt.Fprintf(w, "<table><tr><th>target</th><th>duration</th><th>finish</th></tr>\n")
+	for _, t := range g.CriticalPath {
+		fmt.Fprintf(w, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			g.targetURL(t.Name), t.Duration, t.EarliestFinish)
+	}
+	fmt.Fprintf(w, "</table></body></html>\n")
 
 }
 
@@ -202,6 +225,8 @@ func (g *Graph) Serve(addr string) error {
 	http.HandleFunc("/target", g.ServeTarget)
 	http.HandleFunc("/errors", g.ServeErrors)
 	http.HandleFunc("/command", g.ServeCommand)
+	http.HandleFunc("/trace.json", g.ServeTrace)
+	http.HandleFunc("/diff", g.ServeDiff)
 	http.HandleFunc("/", g.ServeRoot)
 	return http.ListenAndServe(addr, nil)
 }
@@ -0,0 +1,178 @@
+package analyze
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// traceEvent is an event in the Chrome trace event format, which
+// chrome://tracing and ui.perfetto.dev can load.
+type traceEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   float64                `json:"ts"`
+	Dur  float64                `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+type byStart []*Command
+
+func (s byStart) Len() int {
+	return len(s)
+}
+
+func (s byStart) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byStart) Less(i, j int) bool {
+	return s[i].Start().Before(s[j].Start())
+}
+
+// assignSlots puts the commands of each worker on slots, so commands
+// on a slot don't overlap. Commands that didn't run on a worker go
+// under "local". The commands must be sorted by start. It returns
+// the workers in order, and the slot of each command.
+func assignSlots(cmds []*Command) ([]string, map[*Command]int) {
+	var workers []string
+	ends := map[string][]time.Time{}
+	slot := map[*Command]int{}
+	for _, c := range cmds {
+		w := workerName(c)
+		if _, ok := ends[w]; !ok {
+			workers = append(workers, w)
+		}
+		slots := ends[w]
+		i := 0
+		for ; i < len(slots); i++ {
+			if !slots[i].After(c.Start()) {
+				break
+			}
+		}
+		if i == len(slots) {
+			slots = append(slots, time.Time{})
+		}
+		slots[i] = c.Time
+		ends[w] = slots
+		slot[c] = i
+	}
+	sort.Strings(workers)
+	return workers, slot
+}
+
+func workerName(c *Command) string {
+	if c.Worker == "" {
+		return "local"
+	}
+	return c.Worker
+}
+
+func micros(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// traceEvents returns a span for each command, on a track per
+// worker slot, with the phases of the command nested inside it.
+func traceEvents(cmds []*Command) []traceEvent {
+	events := []traceEvent{}
+	if len(cmds) == 0 {
+		return events
+	}
+	cmds = append([]*Command{}, cmds...)
+	sort.Sort(byStart(cmds))
+	workers, slots := assignSlots(cmds)
+	pids := map[string]int{}
+	for i, w := range workers {
+		pids[w] = i + 1
+		events = append(events, traceEvent{
+			Name: "process_name",
+			Ph:   "M",
+			Pid:  i + 1,
+			Args: map[string]interface{}{"name": w},
+		})
+	}
+
+	begin := cmds[0].Start()
+	named := map[[2]int]bool{}
+	for _, c := range cmds {
+		pid, tid := pids[workerName(c)], slots[c]+1
+		if !named[[2]int{pid, tid}] {
+			named[[2]int{pid, tid}] = true
+			events = append(events, traceEvent{
+				Name: "thread_name",
+				Ph:   "M",
+				Pid:  pid,
+				Tid:  tid,
+				Args: map[string]interface{}{"name": fmt.Sprintf("slot %d", tid)},
+			})
+		}
+
+		name := c.Target
+		if name == "" {
+			name = strings.SplitN(c.Command, " ", 2)[0]
+		}
+		start := c.Start().Sub(begin)
+		events = append(events, traceEvent{
+			Name: name,
+			Cat:  "command",
+			Ph:   "X",
+			Ts:   micros(start),
+			Dur:  micros(c.Duration),
+			Pid:  pid,
+			Tid:  tid,
+			Args: map[string]interface{}{
+				"id":      c.ID(),
+				"command": c.Command,
+			},
+		})
+		for _, p := range c.Phases {
+			events = append(events, traceEvent{
+				Name: p.Name,
+				Cat:  "phase",
+				Ph:   "X",
+				Ts:   micros(start + p.Start),
+				Dur:  micros(p.Duration),
+				Pid:  pid,
+				Tid:  tid,
+			})
+		}
+	}
+	return events
+}
+
+// WriteTrace writes the commands as a Chrome trace, with a track for
+// each worker slot.
+func WriteTrace(w io.Writer, cmds []*Command) error {
+	out, err := json.Marshal(&traceFile{
+		TraceEvents:     traceEvents(cmds),
+		DisplayTimeUnit: "ms",
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+func (g *Graph) ServeTrace(w http.ResponseWriter, req *http.Request) {
+	var cmds []*Command
+	for _, c := range g.CommandByID {
+		cmds = append(cmds, c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=trace.json")
+	WriteTrace(w, cmds)
+}
@@ -0,0 +1,68 @@
+package analyze
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAssignSlots(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	cmd := func(worker string, start, dur int) *Command {
+		return &Command{
+			Worker:   worker,
+			Time:     t0.Add(time.Duration(start+dur) * time.Second),
+			Duration: time.Duration(dur) * time.Second,
+		}
+	}
+	a := cmd("w1", 0, 10)
+	b := cmd("w1", 1, 2)
+	c := cmd("w1", 5, 2)
+	d := cmd("", 0, 1)
+	workers, slots := assignSlots([]*Command{a, d, b, c})
+	if len(workers) != 2 || workers[0] != "local" || workers[1] != "w1" {
+		t.Errorf("got workers %v", workers)
+	}
+	if slots[a] != 0 || slots[b] != 1 || slots[c] != 1 || slots[d] != 0 {
+		t.Errorf("got slots a=%d b=%d c=%d d=%d", slots[a], slots[b], slots[c], slots[d])
+	}
+}
+
+func TestWriteTrace(t *testing.T) {
+	t0 := time.Unix(1000, 0)
+	cmds := []*Command{{
+		Target:   "foo.o",
+		Worker:   "w1",
+		Time:     t0.Add(3 * time.Second),
+		Duration: 3 * time.Second,
+		Phases: []Phase{
+			{Name: "remote", Start: time.Second, Duration: time.Second},
+		},
+	}}
+
+	buf := &bytes.Buffer{}
+	if err := WriteTrace(buf, cmds); err != nil {
+		t.Fatalf("WriteTrace: %v", err)
+	}
+	var trace traceFile
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	var spans []traceEvent
+	for _, e := range trace.TraceEvents {
+		if e.Ph == "X" {
+			spans = append(spans, e)
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %v, want 2 spans", spans)
+	}
+	if spans[0].Name != "foo.o" || spans[0].Ts != 0 || spans[0].Dur != 3e6 {
+		t.Errorf("got command span %v", spans[0])
+	}
+	if spans[1].Name != "remote" || spans[1].Ts != 1e6 || spans[1].Dur != 1e6 {
+		t.Errorf("got phase span %v", spans[1])
+	}
+}
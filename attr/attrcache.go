@@ -1,7 +1,9 @@
 package attr
 
 import (
+	"encoding/gob"
 	"fmt"
+	"io"
 	"log"
 	"path/filepath"
 	"strings"
@@ -24,6 +26,9 @@ type AttributeCache struct {
 	clients       map[string]*attrCachePending
 	nextFileSetId int
 
+	// Views that need the old version of entries that change.
+	frozen map[*Frozen]bool
+
 	Paranoia bool
 }
 
@@ -127,6 +132,7 @@ func NewAttributeCache(getter func(n string) *FileAttr,
 	me.getter = getter
 	me.statter = statter
 	me.clients = make(map[string]*attrCachePending)
+	me.frozen = map[*Frozen]bool{}
 	return me
 }
 
@@ -287,6 +293,7 @@ func (me *AttributeCache) update(files []*FileAttr) {
 		if len(r.Path) > 0 && r.Path[0] == '/' {
 			panic("Leading slash.")
 		}
+		me.saveFrozen(r.Path)
 
 		dir, basename := SplitPath(r.Path)
 		if basename != "" {
@@ -298,6 +305,7 @@ func (me *AttributeCache) update(files []*FileAttr) {
 			if dirAttr.NameModeMap == nil {
 				log.Panicf("parent dir has no NameModeMap: %q", dir)
 			}
+			me.saveFrozen(dir)
 			if r.Deletion() {
 				delete(dirAttr.NameModeMap, basename)
 			} else {
@@ -332,15 +340,11 @@ func (me *AttributeCache) Refresh(prefix string) FileSet {
 	me.mutex.Lock()
 	defer me.mutex.Unlock()
 
-	// TODO - how much parallelism is reasonable?
-	par := 10
-
 	if prefix != "" && prefix[0] == '/' {
 		panic("leading /")
 	}
 
-	todo := make(chan *FileAttr, 10*par)
-
+	todo := make(chan *FileAttr, 100)
 	go func() {
 		for key, attr := range me.attributes {
 			if !strings.HasPrefix(key, prefix) {
@@ -350,6 +354,30 @@ func (me *AttributeCache) Refresh(prefix string) FileSet {
 		}
 		close(todo)
 	}()
+	return me.refresh(todo)
+}
+
+// RefreshNames is like Refresh, but only checks the given
+// names. Names that are not in the cache are skipped.
+func (me *AttributeCache) RefreshNames(names []string) FileSet {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+
+	todo := make(chan *FileAttr, len(names))
+	for _, n := range names {
+		if a := me.attributes[n]; a != nil {
+			todo <- a
+		}
+	}
+	close(todo)
+	return me.refresh(todo)
+}
+
+// refresh stats the entries from todo, and updates the ones that
+// changed. Must hold mutex.
+func (me *AttributeCache) refresh(todo chan *FileAttr) FileSet {
+	// TODO - how much parallelism is reasonable?
+	par := 10
 
 	updated := make(chan *FileAttr, 10*par)
 
@@ -390,6 +418,13 @@ func (me *AttributeCache) Refresh(prefix string) FileSet {
 	return fs
 }
 
+// Len returns the number of cached entries.
+func (me *AttributeCache) Len() int {
+	me.mutex.RLock()
+	defer me.mutex.RUnlock()
+	return len(me.attributes)
+}
+
 func (me *AttributeCache) Copy() FileSet {
 	me.mutex.RLock()
 	defer me.mutex.RUnlock()
@@ -407,3 +442,43 @@ func (me *AttributeCache) copyFiles() FileSet {
 	fs.Sort()
 	return fs
 }
+
+// Bump when the FileAttr encoding changes.
+const snapshotVersion = 1
+
+// WriteSnapshot writes the contents of the cache to w, so it can be
+// reloaded with ReadSnapshot.
+func (me *AttributeCache) WriteSnapshot(w io.Writer) error {
+	fs := me.Copy()
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(snapshotVersion); err != nil {
+		return err
+	}
+	return enc.Encode(fs.Files)
+}
+
+// ReadSnapshot reads attributes written by WriteSnapshot. The result
+// is sorted, so it can be passed to Update directly. The attributes
+// may be stale; use Refresh to check them against the file system.
+func ReadSnapshot(r io.Reader) (FileSet, error) {
+	dec := gob.NewDecoder(r)
+	var version int
+	if err := dec.Decode(&version); err != nil {
+		return FileSet{}, err
+	}
+	if version != snapshotVersion {
+		return FileSet{}, fmt.Errorf("snapshot has version %d, want %d", version, snapshotVersion)
+	}
+
+	fs := FileSet{}
+	if err := dec.Decode(&fs.Files); err != nil {
+		return FileSet{}, err
+	}
+	for _, f := range fs.Files {
+		if f.Deletion() || (f.Path != "" && (f.Path[0] == '/' || filepath.Clean(f.Path) != f.Path)) {
+			return FileSet{}, fmt.Errorf("invalid snapshot entry %q", f.Path)
+		}
+	}
+	fs.Sort()
+	return fs, nil
+}
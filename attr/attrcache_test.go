@@ -1,6 +1,7 @@
 package attr
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -151,6 +152,30 @@ func TestAttrCacheRefresh(t *testing.T) {
 	}
 }
 
+func TestAttrCacheRefreshNames(t *testing.T) {
+	ac, dir, clean := attrCacheTestCase(t)
+	defer clean()
+
+	check(ioutil.WriteFile(dir+"/a", []byte{42}, 0644))
+	check(ioutil.WriteFile(dir+"/b", []byte{42}, 0644))
+	ac.Get("a")
+	ac.Get("b")
+
+	check(os.Remove(dir + "/a"))
+	check(os.Remove(dir + "/b"))
+
+	fs := ac.RefreshNames([]string{"a", "unknown"})
+	if len(fs.Files) != 1 || fs.Files[0].Path != "a" || !fs.Files[0].Deletion() {
+		t.Errorf("got %v, want deletion of a", fs.Files)
+	}
+	if ac.Have("a") {
+		t.Errorf("a should have been dropped")
+	}
+	if !ac.Have("b") {
+		t.Errorf("b should not have been refreshed")
+	}
+}
+
 type testClient struct {
 	id    string
 	attrs []*FileAttr
@@ -327,3 +352,78 @@ func TestAttrCacheIncompleteDir(t *testing.T) {
 		t.Errorf("Client should ignore timestamp update to unknown directory: %v", g)
 	}
 }
+
+func TestAttrCacheSnapshot(t *testing.T) {
+	ac, dir, clean := attrCacheTestCase(t)
+	defer clean()
+
+	check(os.Mkdir(dir+"/sub", 0755))
+	check(ioutil.WriteFile(dir+"/sub/file", []byte{42}, 0644))
+	want := ac.Get("sub/file")
+
+	buf := &bytes.Buffer{}
+	if err := ac.WriteSnapshot(buf); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	fs, err := ReadSnapshot(buf)
+	if err != nil {
+		t.Fatalf("ReadSnapshot: %v", err)
+	}
+
+	loaded := NewAttributeCache(
+		func(n string) *FileAttr {
+			t.Errorf("unexpected getter call for %q", n)
+			return nil
+		}, nil)
+	loaded.Paranoia = true
+	loaded.Update(fs.Files)
+	loaded.Verify()
+
+	got := loaded.Get("sub/file")
+	if got.Deletion() || got.Hash != want.Hash || !FuseAttrEq(got.Attr, want.Attr) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if d := loaded.GetDir("sub"); d.NameModeMap["file"] == 0 {
+		t.Errorf("lost directory listing: %v", d)
+	}
+
+	if _, err := ReadSnapshot(bytes.NewBufferString("garbage")); err == nil {
+		t.Errorf("ReadSnapshot should fail on garbage")
+	}
+}
+
+func TestAttrCacheFreeze(t *testing.T) {
+	ac, dir, clean := attrCacheTestCase(t)
+	defer clean()
+
+	check(os.Mkdir(dir+"/sub", 0755))
+	check(ioutil.WriteFile(dir+"/sub/file", []byte{42}, 0644))
+	before := ac.Get("sub/file")
+
+	f := ac.Freeze()
+	defer f.Release()
+
+	check(ioutil.WriteFile(dir+"/sub/file", []byte{43, 44}, 0644))
+	ac.RefreshNames([]string{"sub/file"})
+	check(ioutil.WriteFile(dir+"/sub/new", []byte{1}, 0644))
+	newAttr := GetattrForTest(t, dir+"/sub/new")
+	newAttr.Path = "sub/new"
+	ac.Update([]*FileAttr{newAttr})
+
+	if got := ac.Get("sub/file"); got.Hash == before.Hash {
+		t.Fatalf("cache did not see the change")
+	}
+	if got := f.Get("sub/file"); got.Hash != before.Hash {
+		t.Errorf("frozen view: got hash %x, want %x", got.Hash, before.Hash)
+	}
+	if got := f.Get("sub/new"); !got.Deletion() {
+		t.Errorf("frozen view: got %v for a file created later", got)
+	}
+	if d := f.old["sub"]; d == nil || d.NameModeMap["new"] != 0 {
+		t.Errorf("frozen view: directory lists a file created later: %v", d)
+	}
+	if got := ac.Get("sub/new"); got.Deletion() {
+		t.Errorf("cache lost the new file")
+	}
+}
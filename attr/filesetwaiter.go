@@ -6,6 +6,16 @@ import (
 	"sync"
 )
 
+// ErrDiscarded is returned by Wait if the results of the task were
+// thrown away by the worker.
+var ErrDiscarded = errors.New("results discarded")
+
+// Values sent on the channels.
+const (
+	waitDiscarded = iota
+	waitDone
+)
+
 type FileSetWaiter struct {
 	process func(fset FileSet) error
 	sync.Mutex
@@ -39,7 +49,7 @@ func (me *FileSetWaiter) signal(id int) {
 	defer me.Unlock()
 	ch := me.channels[id]
 	if ch != nil {
-		ch <- 1
+		ch <- waitDone
 		close(ch)
 		delete(me.channels, id)
 	}
@@ -59,6 +69,22 @@ func (me *FileSetWaiter) drop(id int) {
 	delete(me.channels, id)
 }
 
+// Discard tells the tasks waiting for any of taskids that their
+// results are not coming. The caller itself waits for waitId.
+func (me *FileSetWaiter) Discard(taskids []int, waitId int) {
+	me.Lock()
+	defer me.Unlock()
+	for _, id := range taskids {
+		ch := me.channels[id]
+		if ch != nil && id != waitId {
+			// Leave the channel, so late waiters see it.
+			ch <- waitDiscarded
+			close(ch)
+		}
+	}
+	delete(me.channels, waitId)
+}
+
 func (me *FileSetWaiter) Wait(fs *FileSet, taskids []int, waitId int) (err error) {
 	if fs != nil {
 		log.Println("Got data for tasks: ", taskids, fs.Files)
@@ -79,10 +105,13 @@ func (me *FileSetWaiter) Wait(fs *FileSet, taskids []int, waitId int) (err error
 		if completion != nil {
 			// completion may be nil if the response
 			// already came in.
-			_, ok := <-completion
+			v, ok := <-completion
 			if !ok {
 				return errors.New("files were never sent.")
 			}
+			if v == waitDiscarded {
+				err = ErrDiscarded
+			}
 		}
 	}
 	me.drop(waitId)
@@ -0,0 +1,86 @@
+package attr
+
+// Frozen gives the cached attributes as they were when it was
+// created. It only stores the old versions of entries that change
+// afterwards. Names that were not cached yet, and whose directory
+// has not changed since, are read from the cache as they are now.
+type Frozen struct {
+	cache *AttributeCache
+
+	// Old versions of entries changed since the view was created;
+	// nil if they did not exist.
+	old map[string]*FileAttr
+}
+
+// Freeze returns a view of the currently cached attributes. It must
+// be released when no longer needed.
+func (me *AttributeCache) Freeze() *Frozen {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	f := &Frozen{cache: me, old: map[string]*FileAttr{}}
+	me.frozen[f] = true
+	return f
+}
+
+// saveFrozen keeps the current version of name for the frozen
+// views. Must hold mutex.
+func (me *AttributeCache) saveFrozen(name string) {
+	if len(me.frozen) == 0 {
+		return
+	}
+	a, ok := me.attributes[name]
+	if !ok && name != "" {
+		// We know it was absent if its directory is cached.
+		dir, base := SplitPath(name)
+		d := me.attributes[dir]
+		ok = d != nil && d.NameModeMap != nil && d.NameModeMap[base] == 0
+	}
+	if !ok {
+		return
+	}
+	for f := range me.frozen {
+		if _, done := f.old[name]; done {
+			continue
+		}
+		if a == nil {
+			f.old[name] = nil
+		} else {
+			f.old[name] = a.Copy(true)
+		}
+	}
+}
+
+// Get returns the attributes of name as of when the view was
+// created, if they were cached then.
+func (f *Frozen) Get(name string) *FileAttr {
+	c := f.cache
+	c.mutex.RLock()
+	var r *FileAttr
+	if old, ok := f.old[name]; ok {
+		r = &FileAttr{Path: name}
+		if old != nil {
+			r = old.Copy(false)
+		}
+	} else if cur := c.attributes[name]; cur != nil {
+		r = cur.Copy(false)
+	} else if name != "" {
+		// The directory may have changed since.
+		dir, base := SplitPath(name)
+		if d := f.old[dir]; d != nil && d.NameModeMap != nil && d.NameModeMap[base] == 0 {
+			r = &FileAttr{Path: name}
+		}
+	}
+	c.mutex.RUnlock()
+	if r == nil {
+		return c.Get(name)
+	}
+	return r
+}
+
+// Release stops tracking changes for the view.
+func (f *Frozen) Release() {
+	c := f.cache
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.frozen, f)
+}
@@ -31,8 +31,14 @@ type Client struct {
 // NewClient instantiates a Client. The ID string is used for
 // identifying the client in remote logs.
 func NewClient(c io.ReadWriteCloser, id string) *Client {
+	return NewRPCClient(rpc.NewClient(c), id)
+}
+
+// NewRPCClient is like NewClient, but uses an existing RPC client,
+// so the connection can be shared with other services.
+func NewRPCClient(c *rpc.Client, id string) *Client {
 	return &Client{
-		client:  rpc.NewClient(c),
+		client:  c,
 		id:      id,
 		timings: stats.NewTimerStats(),
 	}
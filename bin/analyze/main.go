@@ -2,28 +2,125 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/hanwen/termite/analyze"
 )
 
+// The arguments of each command.
+var commandArgs = map[string]string{
+	"serve": "DIR",
+	"dot":   "DIR",
+	"json":  "DIR",
+	"ninja": "DIR",
+	"trace": "DIR OUT",
+	"diff":  "OLDDIR NEWDIR",
+	"why":   "OLDDIR NEWDIR",
+}
+
+// The commands that flags apply to; flags not listed apply to all.
+var flagCommands = map[string][]string{
+	"addr":       {"serve", "diff"},
+	"regression": {"diff"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [flags] [serve] DIR\n", os.Args[0])
+	for _, c := range []string{"dot", "json", "ninja", "trace", "diff", "why"} {
+		fmt.Fprintf(os.Stderr, "       %s [flags] %s %s\n", os.Args[0], c, commandArgs[c])
+	}
+	flag.PrintDefaults()
+}
+
+func readDir(dir string, re *regexp.Regexp) []*analyze.Command {
+	results, err := analyze.ReadDir(dir, re)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return results
+}
+
 func main() {
 	addr := flag.String("addr", ":8080", "address to serve on")
 	depReStr := flag.String("dep_re", "", "file name regexp for dependency files")
+	regression := flag.Duration("regression", 100*time.Millisecond, "report targets that got this much slower")
+	flag.Usage = usage
 	flag.Parse()
-	dir := flag.Arg(0)
+	args := flag.Args()
+	if len(args) == 1 {
+		args = []string{"serve", args[0]}
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(2)
+	}
+	command, args := args[0], args[1:]
+	if want, ok := commandArgs[command]; !ok || len(args) != len(strings.Fields(want)) {
+		usage()
+		os.Exit(2)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		cmds, ok := flagCommands[f.Name]
+		if !ok {
+			return
+		}
+		for _, c := range cmds {
+			if c == command {
+				return
+			}
+		}
+		log.Fatalf("-%s does not apply to %s", f.Name, command)
+	})
 
 	var re *regexp.Regexp
 	if *depReStr != "" {
 		re = regexp.MustCompile(*depReStr)
 	}
-	results, err := analyze.ReadDir(dir, re)
-	if err != nil {
-		log.Fatal(err)
+
+	if command == "trace" {
+		results := readDir(args[0], re)
+		f, err := os.Create(args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := analyze.WriteTrace(f, results); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	gr := analyze.NewGraph(readDir(args[len(args)-1], re))
+	export := map[string]func(io.Writer) error{
+		"dot":   gr.WriteDot,
+		"json":  gr.WriteJSON,
+		"ninja": gr.WriteNinja,
+	}
+	if write := export[command]; write != nil {
+		if err := write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	gr := analyze.NewGraph(results)
+	if command == "diff" || command == "why" {
+		gr.Diff = analyze.NewDiff(analyze.NewGraph(readDir(args[0], re)), gr)
+	}
+	switch command {
+	case "why":
+		gr.Diff.WriteReasons(os.Stdout)
+		return
+	case "diff":
+		gr.Diff.WriteText(os.Stdout, *regression)
+	}
 
 	log.Printf("serving on %s", *addr)
 	if err := gr.Serve(*addr); err != nil {
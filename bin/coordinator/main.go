@@ -32,6 +32,9 @@ func main() {
 	port := flag.Int("port", 1230, "Where to listen for work requests.")
 	webPassword := flag.String("web-password", "killkillkill", "password for authorizing worker kills.")
 	secretFile := flag.String("secret", "secret.txt", "file containing password or SSH identity.")
+	fairShare := flag.Bool("fair-share", false, "decide how many job slots each master may reserve.")
+	masterQuota := flag.Int("master-quota", 0, "with -fair-share, maximum job slots per master. 0 means no limit.")
+	userQuota := flag.Int("user-quota", 0, "with -fair-share, maximum job slots per user. 0 means no limit.")
 	flag.Parse()
 	log.SetPrefix("C")
 
@@ -43,6 +46,9 @@ func main() {
 	opts := termite.CoordinatorOptions{
 		Secret:      secret,
 		WebPassword: *webPassword,
+		FairShare:   *fairShare,
+		MasterQuota: *masterQuota,
+		UserQuota:   *userQuota,
 	}
 	c := termite.NewCoordinator(&opts)
 	c.Mux.HandleFunc("/bin/worker", serveBin("worker"))
@@ -22,6 +22,9 @@ func main() {
 	fetchAll := flag.Bool("fetch-all", true, "Fetch all files on startup.")
 	houseHoldPeriod := flag.Float64("time.household", 60.0, "how often to do house hold tasks.")
 	jobs := flag.Int("jobs", 1, "number of jobs to run")
+	taskTimeout := flag.Float64("time.task", 0, "kill remote tasks running longer than this many seconds. 0 means no limit.")
+	speculate := flag.Float64("speculate", 0, "duplicate tasks on an idle worker when they take this many times longer than usual. 0 disables.")
+	retryTimeouts := flag.Bool("retry-timeouts", false, "retry tasks that time out on another worker.")
 	keepAlive := flag.Float64("time.keepalive", 60.0, "for how long to keep workers reserved.")
 	logfile := flag.String("logfile", "", "where to send log output.")
 	paranoia := flag.Bool("paranoia", false, "Check attribute cache.")
@@ -32,6 +35,11 @@ func main() {
 	srcRoot := flag.String("sourcedir", "", "root of corresponding source directory")
 	xattr := flag.Bool("xattr", true, "cache hashes in filesystem attribute.")
 	analysisDir := flag.String("analysis-dir", "", "where to store dumps of the action graph")
+	actionCache := flag.Bool("action-cache", false, "replay results of commands whose inputs did not change.")
+	watch := flag.Bool("watch", true, "track file changes with inotify, rather than rescanning after local commands.")
+	snapshot := flag.Bool("attr-snapshot", true, "keep the attribute cache across restarts.")
+	cacheServer := flag.String("cache-server", "", "address of a shared action cache server. Implies -action-cache.")
+	labels := flag.String("labels", "", "only use workers with these labels, eg. pool=build,arch=amd64.")
 	flag.Parse()
 
 	if *logfile != "" {
@@ -50,6 +58,11 @@ func main() {
 		log.Fatal("ReadFile", err)
 	}
 
+	workerLabels, err := termite.ParseLabels(*labels)
+	if err != nil {
+		log.Fatal("-labels: ", err)
+	}
+
 	excludeList := strings.Split(*exclude, ",")
 	root, sock := absSocket(*socket)
 
@@ -58,6 +71,7 @@ func main() {
 		MaxJobs:      *jobs,
 		Excludes:     excludeList,
 		Coordinator:  *coordinator,
+		WorkerLabels: workerLabels,
 		SourceRoot:   *srcRoot,
 		WritableRoot: root,
 		Paranoia:     *paranoia,
@@ -68,10 +82,18 @@ func main() {
 			Dir: *cachedir,
 		},
 		RetryCount:  *retry,
+		TaskTimeout: time.Duration(*taskTimeout * float64(time.Second)),
 		XAttrCache:  *xattr,
 		LogFile:     *logfile,
 		Socket:      sock,
 		AnalysisDir: *analysisDir,
+		ActionCache: *actionCache,
+		CacheServer: *cacheServer,
+
+		SnapshotAttributes: *snapshot,
+		WatchFiles:         *watch,
+		RetryTimeouts:      *retryTimeouts,
+		SpeculationFactor:  *speculate,
 	}
 	master := termite.NewMaster(&opts)
 
@@ -3,10 +3,12 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/rpc"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -38,6 +40,40 @@ func Rpc() (*rpc.Client, error) {
 	return socketRpc, nil
 }
 
+// StreamOutput opens a channel to the master carrying task output,
+// and copies it to w. The returned channel is closed when the stream
+// ends.
+func StreamOutput(w io.Writer) (id string, done chan int) {
+	id = termite.RandomConnectionId()
+	conn := termite.OpenSocketConnection(termite.FindSocket(), id, _TIMEOUT)
+	done = make(chan int)
+	go func() {
+		io.Copy(w, conn)
+		conn.Close()
+		close(done)
+	}()
+	return id, done
+}
+
+// CancelOnSignal cancels the request on the master if we are
+// interrupted.
+func CancelOnSignal(clientId string) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	sig := <-ch
+
+	rpc, err := Rpc()
+	if err == nil {
+		req := termite.CancelRequest{ClientId: clientId}
+		rep := termite.CancelResponse{}
+		err = rpc.Call("LocalMaster.Cancel", &req, &rep)
+	}
+	if err != nil {
+		log.Println("LocalMaster.Cancel:", err)
+	}
+	log.Fatalf("Interrupted by %v", sig)
+}
+
 func TryRunDirect(req *termite.WorkRequest) {
 	if req.Argv[0] == "echo" {
 		fmt.Println(strings.Join(req.Argv[1:], " "))
@@ -108,6 +144,9 @@ func PrepareRun(cmd string, dir string, topdir string) (*termite.WorkRequest, *t
 	rule := decider.ShouldRunLocally(cmd)
 	if rule != nil {
 		req.Debug = rule.Debug
+		req.Limits = rule.Limits
+		req.Labels = rule.Labels
+		req.Timeout = time.Duration(rule.Timeout * float64(time.Second))
 		return req, rule
 	}
 
@@ -203,6 +242,7 @@ func main() {
 	command := flag.String("c", "", "command to run.")
 	refresh := flag.Bool("refresh", false, "refresh master file cache.")
 	shutdown := flag.Bool("shutdown", false, "shutdown master.")
+	abort := flag.Bool("abort", false, "cancel all tasks running on the master.")
 	inspect := flag.Bool("inspect", false, "inspect files on master.")
 	exec := flag.Bool("exec", false, "run command args without shell.")
 	directory := flag.String("dir", "", "directory from where to run (default: cwd).")
@@ -222,6 +262,19 @@ func main() {
 		}
 		return
 	}
+	if *abort {
+		req := termite.AbortAllRequest{}
+		rep := termite.AbortAllResponse{}
+		rpc, err := Rpc()
+		if err == nil {
+			err = rpc.Call("LocalMaster.AbortAll", &req, &rep)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Cancelled %d tasks", rep.Cancelled)
+		return
+	}
 	if *refresh {
 		Refresh()
 	}
@@ -272,15 +325,29 @@ func main() {
 			log.Fatalf("rpc connection problem (%s): %v", *command, err)
 		}
 
+		req.ClientId = termite.RandomConnectionId()
+		go CancelOnSignal(req.ClientId)
+
+		var stdoutDone, stderrDone chan int
+		req.StdoutId, stdoutDone = StreamOutput(os.Stdout)
+		req.StderrId, stderrDone = StreamOutput(os.Stderr)
 		err = rpc.Call("LocalMaster.Run", req, &rep)
 		if err != nil {
 			log.Fatal("LocalMaster.Run: ", err)
 		}
+		<-stdoutDone
+		<-stderrDone
 
 		os.Stdout.Write([]byte(rep.Stdout))
 		os.Stderr.Write([]byte(rep.Stderr))
 
 		waitMsg = rep.Exit
+		if rep.OomKilled {
+			log.Printf("Killed for exceeding the memory limit on %s", rep.WorkerId)
+		}
+		if rep.TimedOut {
+			log.Printf("Killed for running past its timeout on %s", rep.WorkerId)
+		}
 	}
 
 	if waitMsg != 0 {
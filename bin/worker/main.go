@@ -69,6 +69,12 @@ func main() {
 	paranoia := flag.Bool("paranoia", false, "Check attribute cache.")
 	cpus := flag.Int("cpus", 1, "Number of CPUs to use.")
 	heap := flag.Int("heap-size", 0, "Maximum heap size in MB.")
+	cacheSize := flag.Int("cache-size", 0, "Maximum size of the content cache in MB. 0 means unlimited.")
+	cgroup := flag.String("cgroup", "", "cgroup v2 directory for per-task cgroups. Must not contain the worker itself.")
+	taskMemory := flag.Int("task-memory", 0, "Default memory limit per task in MB. Needs -cgroup.")
+	taskCpus := flag.Float64("task-cpus", 0, "Default CPU limit per task. Needs -cgroup.")
+	taskPids := flag.Int("task-pids", 0, "Default process limit per task. Needs -cgroup.")
+	labels := flag.String("labels", "", "labels to register with, eg. pool=bigmem,arch=amd64.")
 	flag.Parse()
 
 	if *version {
@@ -81,6 +87,11 @@ func main() {
 		log.Fatal("ReadFile", err)
 	}
 
+	workerLabels, err := termite.ParseLabels(*labels)
+	if err != nil {
+		log.Fatal("-labels: ", err)
+	}
+
 	if *logfile != "" {
 		f := OpenUniqueLog(*logfile)
 		log.Println("Log output to", *logfile)
@@ -119,12 +130,20 @@ func main() {
 		ReapCount:   *reapcount,
 		LogFileName: *logfile,
 		StoreOptions: cba.StoreOptions{
-			Dir: *cachedir,
+			Dir:     *cachedir,
+			MaxSize: int64(*cacheSize) * (1 << 20),
 		},
 		HeapLimit:   uint64(*heap) * (1 << 20),
 		Coordinator: *coordinator,
 		Port:        *port,
 		PortRetry:   *portRetry,
+		CgroupRoot:  *cgroup,
+		Labels:      workerLabels,
+		Limits: termite.ResourceLimits{
+			Memory: int64(*taskMemory) * (1 << 20),
+			Cpus:   *taskCpus,
+			Pids:   *taskPids,
+		},
 	}
 	if os.Geteuid() == 0 {
 		nobody, err := user.Lookup(*userFlag)
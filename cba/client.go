@@ -18,6 +18,8 @@ type Client struct {
 	mutex    sync.Mutex
 	cond     *sync.Cond
 	fetching map[string]bool
+
+	negotiated sync.Once
 }
 
 func (store *Store) NewClient(conn io.ReadWriteCloser) *Client {
@@ -64,11 +66,23 @@ func (c *Client) Fetch(want string, size int64) (bool, error) {
 	return succ, err
 }
 
+// negotiate asks the server to compress chunks. Servers that don't
+// support it send uncompressed chunks.
+func (c *Client) negotiate() {
+	req := &NegotiateRequest{Codecs: []string{CodecFlate}}
+	rep := &NegotiateResponse{}
+	if err := c.client.Call("Server.Negotiate", req, rep); err != nil {
+		log.Printf("Server.Negotiate: %v", err)
+	}
+}
+
 func (c *Client) fetchChunk(req *Request, rep *Response) error {
+	c.negotiated.Do(c.negotiate)
 	start := time.Now()
 	err := c.client.Call("Server.ServeChunk", req, rep)
 	dt := time.Now().Sub(start)
 	c.store.AddTiming("FetchChunk", rep.Size, dt)
+	c.store.addWireThroughput(int64(len(rep.Chunk)), 0)
 	return err
 }
 
@@ -95,8 +109,10 @@ func (c *Client) fetch(want string, size int64) (bool, error) {
 			return false, err
 		}
 
-		// is this a bug in the rpc package?
-		content := rep.Chunk[:rep.Size]
+		content, err := decodeChunk(rep)
+		if err != nil {
+			return false, err
+		}
 
 		if rep.Last && written == 0 {
 			saved = c.store.Save(content)
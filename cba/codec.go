@@ -0,0 +1,134 @@
+package cba
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codecs for compressing chunks on the wire.
+const (
+	CodecNone  = ""
+	CodecFlate = "flate"
+)
+
+// Chunks that don't shrink below this fraction of their size are
+// sent uncompressed, and so are the remaining chunks of their file.
+const minCompression = 0.9
+
+// How many incompressible files a connection remembers.
+const skipCacheSize = 1024
+
+type NegotiateRequest struct {
+	// Codecs the client can decode, in order of preference.
+	Codecs []string
+}
+
+type NegotiateResponse struct {
+	// The codec the server will use, if it helps.
+	Codec string
+}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, err := flate.NewWriter(nil, flate.BestSpeed)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	},
+}
+
+// codecServer compresses the chunks of a Server, with a codec agreed
+// upon for the connection.
+type codecServer struct {
+	Server
+	store *Store
+
+	mu    sync.Mutex
+	codec string
+
+	// Files found incompressible, keyed by hash. Bounded, as
+	// fetches that are abandoned halfway never clear their entry.
+	skip *LruCache
+}
+
+func newCodecServer(store *Store, s Server) *codecServer {
+	return &codecServer{
+		Server: s,
+		store:  store,
+		skip:   NewLruCache(skipCacheSize),
+	}
+}
+
+func (s *codecServer) Negotiate(req *NegotiateRequest, rep *NegotiateResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range req.Codecs {
+		if c == CodecFlate {
+			s.codec = c
+			break
+		}
+	}
+	rep.Codec = s.codec
+	return nil
+}
+
+func (s *codecServer) ServeChunk(req *Request, rep *Response) error {
+	err := s.Server.ServeChunk(req, rep)
+	if err == nil && rep.Have {
+		s.compress(req, rep)
+	}
+	s.store.addWireThroughput(0, int64(len(rep.Chunk)))
+	return err
+}
+
+func (s *codecServer) compress(req *Request, rep *Response) {
+	s.mu.Lock()
+	codec := s.codec
+	skip := s.skip.Has(req.Hash)
+	if rep.Last {
+		s.skip.Delete(req.Hash)
+	}
+	s.mu.Unlock()
+	if codec == CodecNone || skip || rep.Size == 0 {
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(buf)
+	w.Write(rep.Chunk[:rep.Size])
+	w.Close()
+	flateWriters.Put(w)
+
+	if float64(buf.Len()) >= minCompression*float64(rep.Size) {
+		if !rep.Last {
+			s.mu.Lock()
+			s.skip.Add(req.Hash, true)
+			s.mu.Unlock()
+		}
+		return
+	}
+	rep.Chunk = buf.Bytes()
+	rep.Codec = codec
+}
+
+// decodeChunk returns the content of a chunk.
+func decodeChunk(rep *Response) ([]byte, error) {
+	switch rep.Codec {
+	case CodecNone:
+		return rep.Chunk[:rep.Size], nil
+	case CodecFlate:
+		out := make([]byte, rep.Size)
+		r := flate.NewReader(bytes.NewBuffer(rep.Chunk))
+		defer r.Close()
+		if _, err := io.ReadFull(r, out); err != nil {
+			return nil, fmt.Errorf("flate: %v", err)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", rep.Codec)
+}
@@ -0,0 +1,179 @@
+package cba
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/termite/stats"
+)
+
+// GCStats describes the size of the store and the work done by the
+// garbage collector.
+type GCStats struct {
+	// Content in the store after the last run.
+	Files int
+	Bytes stats.MemCounter
+
+	// Totals over all runs.
+	Runs         int
+	Removed      int
+	RemovedBytes stats.MemCounter
+
+	// Content that was over the limit, but could not be removed,
+	// during the last run.
+	Pinned int
+
+	LastRun      time.Time
+	LastDuration time.Duration
+}
+
+func (s GCStats) String() string {
+	if s.Runs == 0 {
+		return "no garbage collection yet"
+	}
+	return fmt.Sprintf("%d files, %v; %d GC runs removed %d files, %v; last run %v ago took %v, %d files pinned",
+		s.Files, s.Bytes, s.Runs, s.Removed, s.RemovedBytes,
+		time.Now().Sub(s.LastRun), s.LastDuration, s.Pinned)
+}
+
+// touch records that content was used, for LRU eviction. Access
+// times on disk are unreliable with relatime and noatime mounts.
+func (st *Store) touch(hash string) {
+	if st.Options.MaxSize <= 0 {
+		return
+	}
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.used[hash] = time.Now()
+}
+
+// flushUsage writes the recorded uses as access times.
+func (st *Store) flushUsage() {
+	st.mutex.Lock()
+	used := st.used
+	st.used = map[string]time.Time{}
+	st.mutex.Unlock()
+
+	for h, t := range used {
+		p := st.Path(h)
+		if fi, err := os.Lstat(p); err == nil {
+			os.Chtimes(p, t, fi.ModTime())
+		}
+	}
+}
+
+type gcEntry struct {
+	hash string
+	path string
+	size int64
+	used time.Time
+}
+
+type gcEntries []gcEntry
+
+func (e gcEntries) Len() int           { return len(e) }
+func (e gcEntries) Less(i, j int) bool { return e[i].used.Before(e[j].used) }
+func (e gcEntries) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+
+func (st *Store) listContent() (entries gcEntries, total int64) {
+	dirs, err := ioutil.ReadDir(st.Options.Dir)
+	if err != nil {
+		log.Printf("GC: %v", err)
+		return nil, 0
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2 {
+			continue
+		}
+		dir := filepath.Join(st.Options.Dir, d.Name())
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			log.Printf("GC: %v", err)
+			continue
+		}
+		for _, f := range files {
+			h, err := hex.DecodeString(d.Name() + f.Name())
+			if err != nil || !f.Mode().IsRegular() {
+				continue
+			}
+			used := f.ModTime()
+			if a := fuse.ToAttr(f).AccessTime(); a.After(used) {
+				used = a
+			}
+			entries = append(entries, gcEntry{
+				hash: string(h),
+				path: filepath.Join(dir, f.Name()),
+				size: f.Size(),
+				used: used,
+			})
+			total += f.Size()
+		}
+	}
+	return entries, total
+}
+
+// GC removes the least recently used content until the store is
+// below Options.MaxSize. Content in keep, and content used in the
+// last minAge, is never removed.
+func (st *Store) GC(keep map[string]bool, minAge time.Duration) {
+	if st.Options.MaxSize <= 0 {
+		return
+	}
+	start := time.Now()
+	st.flushUsage()
+	entries, total := st.listContent()
+	sort.Sort(entries)
+
+	removed := 0
+	removedBytes := int64(0)
+	pinned := 0
+	for _, e := range entries {
+		if total <= st.Options.MaxSize {
+			break
+		}
+		if keep[e.hash] || start.Sub(e.used) < minAge {
+			pinned++
+			continue
+		}
+		if err := os.Remove(e.path); err != nil {
+			log.Printf("GC: %v", err)
+			continue
+		}
+		removed++
+		removedBytes += e.size
+		total -= e.size
+	}
+	dt := time.Now().Sub(start)
+	st.timings.Log("ContentStore.GC", dt)
+	if removed > 0 {
+		log.Printf("GC removed %d files, %d bytes in %v", removed, removedBytes, dt)
+	}
+
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	s := &st.gcStats
+	s.Files = len(entries) - removed
+	s.Bytes = stats.MemCounter(total)
+	s.Runs++
+	s.Removed += removed
+	s.RemovedBytes += stats.MemCounter(removedBytes)
+	s.Pinned = pinned
+	s.LastRun = start
+	s.LastDuration = dt
+	st.files -= removed
+	st.size -= removedBytes
+}
+
+// GCStats returns statistics of the garbage collector.
+func (st *Store) GCStats() GCStats {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	return st.gcStats
+}
@@ -0,0 +1,89 @@
+package cba
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hanwen/termite/stats"
+)
+
+func TestStoreGC(t *testing.T) {
+	tc := newCcTestCase()
+	defer tc.Clean()
+
+	var hashes []string
+	for i, c := range []string{"aaaaaaaaaa", "bbbbbbbbbb", "cccccccccc"} {
+		h := tc.store.Save([]byte(c))
+		when := time.Now().Add(time.Duration(i-10) * time.Hour)
+		check(os.Chtimes(tc.store.Path(h), when, when))
+		hashes = append(hashes, h)
+	}
+	a, b, c := hashes[0], hashes[1], hashes[2]
+
+	// Without a limit, nothing happens.
+	tc.store.GC(nil, 0)
+	if s := tc.store.GCStats(); s.Runs != 0 {
+		t.Errorf("GC ran without limit: %v", s)
+	}
+
+	tc.options.MaxSize = 20
+	tc.store.GC(map[string]bool{a: true}, 0)
+	if !tc.store.Has(a) || tc.store.Has(b) || !tc.store.Has(c) {
+		t.Errorf("want a and c to survive, got %v %v %v",
+			tc.store.Has(a), tc.store.Has(b), tc.store.Has(c))
+	}
+	s := tc.store.GCStats()
+	if s.Runs != 1 || s.Removed != 1 || s.RemovedBytes != 10 || s.Files != 2 || s.Bytes != 20 || s.Pinned != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// Recently used content is kept; the Has() calls above
+	// count as use.
+	tc.options.MaxSize = 1
+	tc.store.GC(nil, time.Hour)
+	if !tc.store.Has(a) || !tc.store.Has(c) {
+		t.Errorf("recently used content was removed")
+	}
+
+	tc.store.GC(nil, 0)
+	if tc.store.Has(a) || tc.store.Has(c) {
+		t.Errorf("content over limit survived")
+	}
+}
+
+func TestStoreMetricsSize(t *testing.T) {
+	tc := newCcTestCase()
+	defer tc.Clean()
+	a := tc.store.Save([]byte("aaaaaaaaaa"))
+	tc.store.Save([]byte("aaaaaaaaaa"))
+	tc.store.Save([]byte("bbbbb"))
+
+	// Saving the same content twice doesn't count.
+	metrics := func(s *Store) string {
+		buf := &bytes.Buffer{}
+		s.WriteMetrics(stats.NewMetricsWriter(buf), "store")
+		return buf.String()
+	}
+	if got := metrics(tc.store); !strings.Contains(got, "store_size_bytes 15\n") ||
+		!strings.Contains(got, "store_files 2\n") {
+		t.Errorf("want 2 files, 15 bytes without GC:\n%s", got)
+	}
+
+	// The size of existing content is measured on opening.
+	reopened := NewStore(tc.options, nil)
+	if got := metrics(reopened); !strings.Contains(got, "store_size_bytes 15\n") {
+		t.Errorf("want 15 bytes after reopening:\n%s", got)
+	}
+
+	old := time.Now().Add(-time.Hour)
+	check(os.Chtimes(tc.store.Path(a), old, old))
+	tc.options.MaxSize = 6
+	tc.store.GC(nil, 0)
+	if got := metrics(tc.store); !strings.Contains(got, "store_size_bytes 5\n") ||
+		!strings.Contains(got, "store_files 1\n") {
+		t.Errorf("want 1 file, 5 bytes after GC:\n%s", got)
+	}
+}
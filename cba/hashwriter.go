@@ -57,10 +57,14 @@ func (st *HashWriter) Close() error {
 	sum := st.Sum()
 	sumpath := HashPath(dir, sum)
 
+	_, statErr := os.Lstat(sumpath)
 	err = os.Rename(src, sumpath)
 	if err != nil {
 		log.Fatal("Rename failed", err)
 	}
+	if statErr != nil {
+		st.cache.added(int64(st.size))
+	}
 
 	dt := time.Now().Sub(st.start)
 
@@ -74,6 +74,16 @@ func (me *LruCache) Has(key string) bool {
 	return ok
 }
 
+// Delete removes key, if present.
+func (me *LruCache) Delete(key string) {
+	e, ok := me.contents[key]
+	if !ok {
+		return
+	}
+	delete(me.contents, key)
+	me.lastUsedKeys[e.index] = nil
+}
+
 func (me *LruCache) Size() int {
 	return len(me.contents)
 }
@@ -58,3 +58,20 @@ func TestLruCacheDistance(t *testing.T) {
 		t.Errorf("got average age %d, want 4.", d)
 	}
 }
+
+func TestLruCacheDelete(t *testing.T) {
+	c := NewLruCache(2)
+	c.Add("1", 1)
+	c.Add("2", 2)
+	c.Delete("1")
+	c.Delete("0")
+	if c.Has("1") || c.Size() != 1 {
+		t.Errorf("key 1 not deleted, size %d", c.Size())
+	}
+
+	c.Add("3", 3)
+	c.Add("4", 4)
+	if c.Has("2") || !c.Has("3") || !c.Has("4") {
+		t.Errorf("want keys 3 and 4 after eviction")
+	}
+}
@@ -0,0 +1,31 @@
+package cba
+
+import (
+	"github.com/hanwen/termite/stats"
+)
+
+// WriteMetrics writes the bytes transferred by the store, and the
+// size of the store.
+func (st *Store) WriteMetrics(m *stats.MetricsWriter, prefix string) {
+	st.mutex.Lock()
+	received, served := st.bytesReceived, st.bytesServed
+	wireReceived, wireServed := st.wireReceived, st.wireServed
+	gc := st.gcStats
+	files, size := st.files, st.size
+	st.mutex.Unlock()
+
+	m.Counter(prefix+"_bytes_total", "Content transferred.",
+		float64(received), "direction", "received")
+	m.Counter(prefix+"_bytes_total", "Content transferred.",
+		float64(served), "direction", "served")
+	m.Counter(prefix+"_wire_bytes_total", "Content transferred, after compression.",
+		float64(wireReceived), "direction", "received")
+	m.Counter(prefix+"_wire_bytes_total", "Content transferred, after compression.",
+		float64(wireServed), "direction", "served")
+
+	m.Gauge(prefix+"_files", "Files in the store.", float64(files))
+	m.Gauge(prefix+"_size_bytes", "Size of the store.", float64(size))
+	m.Counter(prefix+"_gc_runs_total", "Garbage collection runs.", float64(gc.Runs))
+	m.Counter(prefix+"_gc_removed_files_total", "Files removed by garbage collection.", float64(gc.Removed))
+	m.Counter(prefix+"_gc_removed_bytes_total", "Bytes removed by garbage collection.", float64(gc.RemovedBytes))
+}
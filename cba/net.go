@@ -10,7 +10,7 @@ import (
 var defaultServeSize = 64 * (1 << 10)
 
 func (c *Store) ServeConn(conn io.ReadWriteCloser) {
-	s := c.newServer()
+	s := newCodecServer(c, c.newServer())
 	rpcServer := rpc.NewServer()
 	rpcServer.RegisterName("Server", s)
 	rpcServer.ServeConn(conn)
@@ -2,6 +2,7 @@ package cba
 
 import (
 	"bytes"
+	"crypto/rand"
 	"io"
 	"io/ioutil"
 	"net"
@@ -98,3 +99,30 @@ func TestNetLargeFile(t *testing.T) {
 		t.Errorf("after fetch, the hash should be there")
 	}
 }
+
+func TestNetCompression(t *testing.T) {
+	tc := newNetTestCase(t)
+	defer tc.Clean()
+
+	text := bytes.Repeat([]byte("int main(int argc, char **argv);\n"), 10000)
+	random := make([]byte, 200*1024)
+	rand.Read(random)
+
+	for _, content := range [][]byte{text, random} {
+		hash := tc.server.Save(content)
+		if got, err := tc.client.Fetch(hash, int64(len(content))); !got || err != nil {
+			t.Fatalf("Fetch: %v, %v", got, err)
+		}
+		if !tc.clientStore.Has(hash) {
+			t.Errorf("after fetch, the hash should be there")
+		}
+	}
+
+	tc.server.mutex.Lock()
+	raw, wire := tc.server.bytesServed, tc.server.wireServed
+	tc.server.mutex.Unlock()
+	want := len(text)/2 + len(random)
+	if int(wire) > want || raw <= wire {
+		t.Errorf("served %d bytes as %d on the wire, want at most %d", raw, wire, want)
+	}
+}
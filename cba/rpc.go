@@ -14,8 +14,12 @@ func (me *Request) String() string {
 }
 
 type Response struct {
+	// Size of the chunk content, before encoding.
 	Size  int
 	Have  bool
 	Last  bool
 	Chunk []byte
+
+	// How Chunk is encoded.
+	Codec string
 }
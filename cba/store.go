@@ -24,11 +24,25 @@ type Store struct {
 	mutex         sync.Mutex
 	bytesServed   stats.MemCounter
 	bytesReceived stats.MemCounter
+	wireServed    stats.MemCounter
+	wireReceived  stats.MemCounter
+
+	// Last use of content since the last GC.
+	used    map[string]time.Time
+	gcStats GCStats
+
+	// Content in the store, measured when it is opened.
+	files int
+	size  int64
 }
 
 type StoreOptions struct {
 	Hash crypto.Hash
 	Dir  string
+
+	// If positive, GC removes content until the store is below
+	// this many bytes.
+	MaxSize int64
 }
 
 // NewStore creates a content cache based in directory d.
@@ -51,7 +65,10 @@ func NewStore(options *StoreOptions, timings *stats.TimerStats) *Store {
 	c := &Store{
 		Options: options,
 		timings: timings,
+		used:    map[string]time.Time{},
 	}
+	entries, total := c.listContent()
+	c.files, c.size = len(entries), total
 	c.initThroughputSampler()
 	return c
 }
@@ -84,7 +101,11 @@ func HashPath(dir string, hash string) string {
 
 func (st *Store) Has(hash string) bool {
 	_, err := os.Lstat(st.Path(hash))
-	return err == nil
+	if err != nil {
+		return false
+	}
+	st.touch(hash)
+	return true
 }
 
 func (st *Store) Path(hash string) string {
@@ -132,6 +153,7 @@ func (st *Store) DestructiveSavePath(path string) (hash string, err error) {
 	if err != nil {
 		log.Fatal("Rename failed", err)
 	}
+	st.added(size)
 	f.Chmod(0444)
 	after, _ := f.Stat()
 	if !after.ModTime().Equal(before.ModTime()) || after.Size() != before.Size() {
@@ -180,6 +202,14 @@ func (st *Store) SaveStream(input io.Reader, size int64) (hash string) {
 	return dup.Sum()
 }
 
+// added records new content of the given size.
+func (st *Store) added(size int64) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.files++
+	st.size += size
+}
+
 func (st *Store) AddTiming(name string, bytes int, dt time.Duration) {
 	st.timings.Log("ContentStore."+name, dt)
 	st.timings.LogN("ContentStore."+name+"Bytes", int64(bytes), dt)
@@ -10,7 +10,12 @@ import (
 func (st *Store) initThroughputSampler() {
 	st.throughput = stats.NewPeriodicSampler(time.Second, 60, func() stats.Sample {
 		st.mutex.Lock()
-		s := &ThroughputSample{received: st.bytesReceived, served: st.bytesServed}
+		s := &ThroughputSample{
+			received:     st.bytesReceived,
+			served:       st.bytesServed,
+			wireReceived: st.wireReceived,
+			wireServed:   st.wireServed,
+		}
 		st.mutex.Unlock()
 		return s
 	})
@@ -18,6 +23,9 @@ func (st *Store) initThroughputSampler() {
 
 type ThroughputSample struct {
 	served, received stats.MemCounter
+
+	// Bytes on the wire, after compression.
+	wireServed, wireReceived stats.MemCounter
 }
 
 func (s *ThroughputSample) CopySample() stats.Sample {
@@ -25,28 +33,45 @@ func (s *ThroughputSample) CopySample() stats.Sample {
 	return &t
 }
 
+// ratio returns the compression ratio for the given raw and wire
+// byte counts.
+func ratio(raw, wire stats.MemCounter) string {
+	if wire == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", float64(raw)/float64(wire))
+}
+
 func (s *ThroughputSample) String() string {
-	return fmt.Sprintf("received %v, sent %v", s.received, s.served)
+	return fmt.Sprintf("received %v (ratio %s), sent %v (ratio %s)",
+		s.received, ratio(s.received, s.wireReceived),
+		s.served, ratio(s.served, s.wireServed))
 }
 
 func (s *ThroughputSample) SubtractSample(r stats.Sample) {
 	t := r.(*ThroughputSample)
 	s.served -= t.served
 	s.received -= t.received
+	s.wireServed -= t.wireServed
+	s.wireReceived -= t.wireReceived
 }
 
 func (s *ThroughputSample) AddSample(r stats.Sample) {
 	t := r.(*ThroughputSample)
 	s.served += t.served
 	s.received += t.received
+	s.wireServed += t.wireServed
+	s.wireReceived += t.wireReceived
 }
 
 func (s *ThroughputSample) TableHeader() string {
-	return "<tr><th>received</th><th>served</th></tr>"
+	return "<tr><th>received</th><th>ratio</th><th>served</th><th>ratio</th></tr>"
 }
 
 func (s *ThroughputSample) TableRow() string {
-	return fmt.Sprintf("<tr><td>%v</td><td>%v</td></tr>", s.received, s.served)
+	return fmt.Sprintf("<tr><td>%v</td><td>%s</td><td>%v</td><td>%s</td></tr>",
+		s.received, ratio(s.received, s.wireReceived),
+		s.served, ratio(s.served, s.wireServed))
 }
 
 func (st *Store) ThroughputStats() []stats.Sample {
@@ -59,3 +84,10 @@ func (st *Store) addThroughput(received, served int64) {
 	st.bytesReceived += stats.MemCounter(received)
 	st.bytesServed += stats.MemCounter(served)
 }
+
+func (st *Store) addWireThroughput(received, served int64) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.wireReceived += stats.MemCounter(received)
+	st.wireServed += stats.MemCounter(served)
+}
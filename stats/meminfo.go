@@ -0,0 +1,34 @@
+package stats
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetMemAvailable returns the memory that the machine can give to
+// new processes without swapping, or 0 if unknown.
+func GetMemAvailable() MemCounter {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	return parseMemAvailable(bufio.NewScanner(f))
+}
+
+func parseMemAvailable(scanner *bufio.Scanner) MemCounter {
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0
+		}
+		return MemCounter(kb << 10)
+	}
+	return 0
+}
@@ -0,0 +1,17 @@
+package stats
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestParseMemAvailable(t *testing.T) {
+	in := "MemTotal:       16307948 kB\nMemFree:          481332 kB\nMemAvailable:    9004312 kB\n"
+	if got := parseMemAvailable(bufio.NewScanner(strings.NewReader(in))); got != 9004312<<10 {
+		t.Errorf("got %d", got)
+	}
+	if got := parseMemAvailable(bufio.NewScanner(strings.NewReader("MemTotal: 1 kB\n"))); got != 0 {
+		t.Errorf("got %d for missing field", got)
+	}
+}
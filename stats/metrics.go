@@ -0,0 +1,132 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsContentType is the content type of the Prometheus text
+// format.
+const MetricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsWriter writes metrics in the Prometheus text format. Samples
+// of one metric must be written consecutively.
+type MetricsWriter struct {
+	w    io.Writer
+	seen map[string]bool
+}
+
+func NewMetricsWriter(w io.Writer) *MetricsWriter {
+	return &MetricsWriter{w: w, seen: map[string]bool{}}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// write emits a sample. Labels are given as name, value pairs.
+func (m *MetricsWriter) write(kind, name, help string, v float64, labels []string) {
+	if !m.seen[name] {
+		m.seen[name] = true
+		fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	l := ""
+	if len(labels) > 0 {
+		var kvs []string
+		for i := 0; i+1 < len(labels); i += 2 {
+			kvs = append(kvs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+		}
+		l = "{" + strings.Join(kvs, ",") + "}"
+	}
+	fmt.Fprintf(m.w, "%s%s %g\n", name, l, v)
+}
+
+func (m *MetricsWriter) Counter(name, help string, v float64, labels ...string) {
+	m.write("counter", name, help, v, labels)
+}
+
+func (m *MetricsWriter) Gauge(name, help string, v float64, labels ...string) {
+	m.write("gauge", name, help, v, labels)
+}
+
+// Timings writes the call counts and total durations of the timer.
+func (m *MetricsWriter) Timings(prefix string, t *TimerStats) {
+	timings := t.Timings()
+	names := []string{}
+	for n := range timings {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		m.Counter(prefix+"_calls_total", "Number of calls.",
+			float64(timings[n].N), "call", n)
+	}
+	for _, n := range names {
+		m.Counter(prefix+"_seconds_total", "Time spent in calls.",
+			timings[n].Duration.Seconds(), "call", n)
+	}
+}
+
+// Counters is a set of named counters.
+type Counters struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+}
+
+func NewCounters() *Counters {
+	return &Counters{counts: map[string]int64{}}
+}
+
+func (c *Counters) Add(name string, n int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.counts[name] += n
+}
+
+func (c *Counters) Counts() map[string]int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	r := map[string]int64{}
+	for k, v := range c.counts {
+		r[k] = v
+	}
+	return r
+}
+
+// WriteMetrics writes the counters as one metric, with the counter
+// names in the given label.
+func (c *Counters) WriteMetrics(m *MetricsWriter, name, help, label string) {
+	counts := c.Counts()
+	keys := []string{}
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		m.Counter(name, help, float64(counts[k]), label, k)
+	}
+}
+
+// WriteMetrics writes the number of jobs in each phase, and the CPU
+// time of the process.
+func (me *ServerStats) WriteMetrics(m *MetricsWriter, prefix string) {
+	counts := me.PhaseCounts()
+	for i, n := range me.PhaseOrder {
+		m.Gauge(prefix+"_phase_jobs", "Jobs in each phase.", float64(counts[i]), "phase", n)
+	}
+
+	cpu := TotalCpuStat()
+	for _, c := range []struct {
+		mode string
+		d    time.Duration
+	}{
+		{"user", cpu.SelfCpu},
+		{"system", cpu.SelfSys},
+		{"child_user", cpu.ChildCpu},
+		{"child_system", cpu.ChildSys},
+	} {
+		m.Counter(prefix+"_cpu_seconds_total", "CPU time used.", c.d.Seconds(), "mode", c.mode)
+	}
+}
@@ -0,0 +1,41 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewMetricsWriter(buf)
+	m.Gauge("jobs", "Jobs.", 2, "phase", "run")
+	m.Gauge("jobs", "Jobs.", 1.5, "phase", "a\"b")
+
+	timer := NewTimerStats()
+	timer.Log("Fetch", time.Second)
+	timer.Log("Fetch", time.Second)
+	m.Timings("rpc", timer)
+
+	c := NewCounters()
+	c.Add("ok", 3)
+	c.WriteMetrics(m, "tasks_total", "Tasks.", "outcome")
+
+	want := `# HELP jobs Jobs.
+# TYPE jobs gauge
+jobs{phase="run"} 2
+jobs{phase="a\"b"} 1.5
+# HELP rpc_calls_total Number of calls.
+# TYPE rpc_calls_total counter
+rpc_calls_total{call="Fetch"} 2
+# HELP rpc_seconds_total Time spent in calls.
+# TYPE rpc_seconds_total counter
+rpc_seconds_total{call="Fetch"} 2
+# HELP tasks_total Tasks.
+# TYPE tasks_total counter
+tasks_total{outcome="ok"} 3
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,367 @@
+package termite
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hanwen/termite/attr"
+	"github.com/hanwen/termite/cba"
+)
+
+// actionCache maps digests of commands to digests of their results.
+// The results themselves live in the content store.
+//
+// Lookups go in two steps: the command (binary, arguments,
+// environment, directory) selects a manifest listing the files the
+// command read last time. The command key together with the current
+// hashes of those files then selects the cached result.
+type actionCache struct {
+	store *cba.Store
+	dir   string
+
+	// If set, consulted on local misses, and populated with new
+	// results.
+	remote *remoteActionCache
+}
+
+// cachedManifest lists the files a command read on its last run.
+type cachedManifest struct {
+	Reads []string
+}
+
+// cachedAction is the replayable part of a WorkResponse.
+type cachedAction struct {
+	Exit   syscall.WaitStatus
+	Stdout string
+	Stderr string
+	Files  []*attr.FileAttr
+}
+
+func newActionCache(store *cba.Store, dir string) *actionCache {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatalf("MkdirAll(%q): %v", dir, err)
+	}
+	return &actionCache{
+		store: store,
+		dir:   dir,
+	}
+}
+
+// get returns the content hash stored under key, or "" if there is
+// none.
+func (c *actionCache) get(key string) string {
+	if h := c.getLocal(key); h != "" {
+		return h
+	}
+	if c.remote == nil {
+		return ""
+	}
+	h := c.remote.get(key)
+	if h != "" {
+		c.set(key, h)
+	}
+	return h
+}
+
+func (c *actionCache) getLocal(key string) string {
+	content, err := ioutil.ReadFile(cba.HashPath(c.dir, key))
+	if err != nil {
+		return ""
+	}
+	h := string(content)
+	if !c.store.Has(h) {
+		return ""
+	}
+	return h
+}
+
+// have returns whether the content store has the given hash,
+// fetching it from the remote cache if possible.
+func (c *actionCache) have(hash string, size int64) bool {
+	if c.store.Has(hash) {
+		return true
+	}
+	return c.remote != nil && c.remote.have(hash, size)
+}
+
+// set stores hash under key.
+func (c *actionCache) set(key string, hash string) {
+	p := cba.HashPath(c.dir, key)
+	f, err := ioutil.TempFile(filepath.Dir(p), ".actiontmp")
+	if err != nil {
+		log.Printf("actionCache.set: %v", err)
+		return
+	}
+	f.Write([]byte(hash))
+	f.Close()
+	if err := os.Rename(f.Name(), p); err != nil {
+		log.Printf("actionCache.set: %v", err)
+		os.Remove(f.Name())
+	}
+}
+
+// getJSON unmarshals the content stored under key into v.
+func (c *actionCache) getJSON(key string, v interface{}) bool {
+	h := c.get(key)
+	if h == "" {
+		return false
+	}
+	content, err := ioutil.ReadFile(c.store.Path(h))
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		log.Printf("actionCache: corrupt entry %x: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// setJSON stores the serialization of v under key. The blobs are
+// content that v refers to. It returns the request that stores the
+// entry and the blobs in the remote cache, or nil if there is none.
+func (c *actionCache) setJSON(key string, v interface{}, blobs []ActionBlob) *ActionPutRequest {
+	content, err := json.Marshal(v)
+	if err != nil {
+		log.Panicf("Marshal: %v", err)
+	}
+	h := c.store.Save(content)
+	if h == "" {
+		return nil
+	}
+	c.set(key, h)
+	if c.remote == nil {
+		return nil
+	}
+	self := ActionBlob{Hash: h, Size: int64(len(content))}
+	return &ActionPutRequest{
+		Key:        key,
+		ActionBlob: self,
+		Blobs:      append(blobs, self),
+	}
+}
+
+// upload sends the entries to the remote cache in the background,
+// in order.
+func (c *actionCache) upload(reqs ...*ActionPutRequest) {
+	go func() {
+		for _, r := range reqs {
+			if r != nil {
+				c.remote.put(r)
+			}
+		}
+	}()
+}
+
+func (c *actionCache) hash(pieces ...string) string {
+	h := c.store.HashType().New()
+	for _, p := range pieces {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	return string(h.Sum(nil))
+}
+
+// commandKey returns the key for the command; binaryHash is the
+// content hash of the binary.
+func (c *actionCache) commandKey(req *WorkRequest, binaryHash string) string {
+	pieces := []string{"cmd", req.Binary, binaryHash, req.Dir}
+	pieces = append(pieces, req.Argv...)
+	pieces = append(pieces, "")
+	pieces = append(pieces, req.Env...)
+	return c.hash(pieces...)
+}
+
+// inputKey returns the key for the command with the given inputs.
+// hashes has one entry for each entry of reads.
+func (c *actionCache) inputKey(cmdKey string, reads []string, hashes []string) string {
+	pieces := []string{"input", cmdKey}
+	for i, r := range reads {
+		pieces = append(pieces, r, hashes[i])
+	}
+	return c.hash(pieces...)
+}
+
+// attrGetter looks up attributes, as they are now or as they were
+// when a task was sent.
+type attrGetter interface {
+	Get(name string) *attr.FileAttr
+}
+
+// inputHashes returns a content key for each of the files read by a
+// task. The paths are relative to the writable root. It returns false
+// if some read can't be keyed by its content: files outside the
+// writable root, and directories.
+func (m *Master) inputHashes(attrs attrGetter, reads []string) ([]string, bool) {
+	wrRoot := strings.TrimLeft(m.options.WritableRoot, "/")
+	hashes := make([]string, 0, len(reads))
+	ok := true
+	for _, r := range reads {
+		if filepath.IsAbs(r) || r == ".." || strings.HasPrefix(r, "../") {
+			hashes = append(hashes, "outside")
+			ok = false
+			continue
+		}
+		a := attrs.Get(filepath.Join(wrRoot, r))
+		switch {
+		case a.Deletion():
+			hashes = append(hashes, "deleted")
+		case a.IsRegular():
+			hashes = append(hashes, a.Hash)
+		case a.IsSymlink():
+			hashes = append(hashes, "link:"+a.Link)
+		default:
+			hashes = append(hashes, attr.FileMode(a.Mode).String())
+			ok = false
+		}
+	}
+	return hashes, ok
+}
+
+func (m *Master) binaryHash(binary string) string {
+	a := m.attributes.Get(strings.TrimLeft(binary, "/"))
+	return a.Hash
+}
+
+// cacheableRequest returns whether the result of req may be served
+// from or stored in the action cache.
+func cacheableRequest(req *WorkRequest) bool {
+	return req.StdinConn == nil && req.StdinId == "" && req.Worker == ""
+}
+
+// lookupAction tries to serve the request from the action cache. It
+// returns true if it did.
+func (m *Master) lookupAction(req *WorkRequest, rep *WorkResponse) bool {
+	c := m.actions
+	if c == nil || !cacheableRequest(req) {
+		return false
+	}
+	start := time.Now()
+	cmdKey := c.commandKey(req, m.binaryHash(req.Binary))
+
+	var manifest cachedManifest
+	if !c.getJSON(cmdKey, &manifest) {
+		return false
+	}
+	hashes, ok := m.inputHashes(m.attributes, manifest.Reads)
+	if !ok {
+		return false
+	}
+	var action cachedAction
+	if !c.getJSON(c.inputKey(cmdKey, manifest.Reads, hashes), &action) {
+		return false
+	}
+	for _, f := range action.Files {
+		if f.Hash != "" && !c.have(f.Hash, int64(f.Size)) {
+			return false
+		}
+	}
+
+	fset, ok := m.cachedFileSet(action.Files)
+	if !ok {
+		return false
+	}
+	m.replay(fset)
+	rep.FileSet = &fset
+	rep.Exit = action.Exit
+	rep.Stdout = action.Stdout
+	rep.Stderr = action.Stderr
+	rep.Reads = manifest.Reads
+	rep.WorkerId = "(action cache)"
+	m.timing.Log("Master.ActionCacheHit", time.Now().Sub(start))
+	log.Printf("Action cache hit for task %d: %v", req.TaskId, req.Argv)
+	return true
+}
+
+// cachedFileSet prepares cached results for replaying into the
+// current state of the tree. Deletions of files that are already
+// gone and directories that already exist are dropped, and all
+// timestamps are set to now, so make sees the outputs as fresh. It
+// returns false if the results can't be replayed, eg. because an
+// output directory has disappeared.
+func (m *Master) cachedFileSet(files []*attr.FileAttr) (attr.FileSet, bool) {
+	now := time.Now()
+	fset := attr.FileSet{}
+	newDirs := map[string]bool{}
+	for _, f := range files {
+		cur := m.attributes.Get(f.Path)
+		if f.Deletion() {
+			if !cur.Deletion() {
+				fset.Files = append(fset.Files, f)
+			}
+			continue
+		}
+		if f.IsDir() {
+			if cur.IsDir() {
+				continue
+			}
+			newDirs[f.Path] = true
+		}
+		f.SetTimes(&now, &now, &now)
+		fset.Files = append(fset.Files, f)
+	}
+	for _, f := range fset.Files {
+		if f.Deletion() {
+			continue
+		}
+		dir, _ := SplitPath(f.Path)
+		if !newDirs[dir] && !m.attributes.Get(dir).IsDir() {
+			return fset, false
+		}
+	}
+	fset.Sort()
+	return fset, true
+}
+
+// storeAction records the result of a successfully executed request.
+func (m *Master) storeAction(req *WorkRequest, rep *WorkResponse) {
+	c := m.actions
+	if c == nil || !cacheableRequest(req) {
+		return
+	}
+	// If the worker batched several tasks in one reap, we can't
+	// tell which files belong to this task.
+	if rep.Exit != 0 || rep.FileSet == nil ||
+		len(rep.TaskIds) != 1 || rep.TaskIds[0] != req.TaskId {
+		return
+	}
+
+	// Hash the inputs as the worker saw them; they may have
+	// changed since.
+	if rep.inputs == nil {
+		return
+	}
+	reads := append([]string{}, rep.Reads...)
+	sort.Strings(reads)
+	hashes, ok := m.inputHashes(rep.inputs, reads)
+	if !ok {
+		return
+	}
+	cmdKey := c.commandKey(req, m.binaryHash(req.Binary))
+	var blobs []ActionBlob
+	for _, f := range rep.FileSet.Files {
+		if f.Hash != "" {
+			blobs = append(blobs, ActionBlob{Hash: f.Hash, Size: int64(f.Size)})
+		}
+	}
+
+	// Store the action before the manifest that leads to it, so
+	// a reader that finds the manifest also finds the action.
+	action := c.setJSON(c.inputKey(cmdKey, reads, hashes), &cachedAction{
+		Exit:   rep.Exit,
+		Stdout: rep.Stdout,
+		Stderr: rep.Stderr,
+		Files:  rep.FileSet.Files,
+	}, blobs)
+	manifest := c.setJSON(cmdKey, &cachedManifest{Reads: reads}, nil)
+	if c.remote != nil {
+		c.upload(action, manifest)
+	}
+}
@@ -0,0 +1,118 @@
+package termite
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/hanwen/termite/cba"
+)
+
+func TestActionCacheKeys(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	store := cba.NewStore(&cba.StoreOptions{Dir: dir + "/store"}, nil)
+	c := newActionCache(store, dir+"/actions")
+
+	req := WorkRequest{
+		Binary: "/bin/cc",
+		Argv:   []string{"cc", "-c", "a.c"},
+		Env:    []string{"PATH=/bin"},
+		Dir:    "/src",
+	}
+	k1 := c.commandKey(&req, "h1")
+	if k2 := c.commandKey(&req, "h1"); k1 != k2 {
+		t.Errorf("commandKey not deterministic: %x != %x", k1, k2)
+	}
+	if k := c.commandKey(&req, "h2"); k == k1 {
+		t.Errorf("commandKey ignores binary hash")
+	}
+	req.Env = []string{"PATH=/usr/bin"}
+	if k := c.commandKey(&req, "h1"); k == k1 {
+		t.Errorf("commandKey ignores environment")
+	}
+
+	reads := []string{"a.c", "a.h"}
+	i1 := c.inputKey(k1, reads, []string{"x", "y"})
+	if i2 := c.inputKey(k1, reads, []string{"x", "z"}); i1 == i2 {
+		t.Errorf("inputKey ignores input hashes")
+	}
+}
+
+func TestActionCacheStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	store := cba.NewStore(&cba.StoreOptions{Dir: dir + "/store"}, nil)
+	c := newActionCache(store, dir+"/actions")
+
+	key := c.hash("key")
+	var got cachedAction
+	if c.getJSON(key, &got) {
+		t.Fatalf("found entry in empty cache: %v", got)
+	}
+
+	c.setJSON(key, &cachedAction{Stdout: "hello", Exit: 1 << 8}, nil)
+	if !c.getJSON(key, &got) {
+		t.Fatalf("entry not found")
+	}
+	if got.Stdout != "hello" || got.Exit.ExitStatus() != 1 {
+		t.Errorf("got %#v, want stdout hello, exit 1", got)
+	}
+}
+
+func TestCacheServer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	secret := make([]byte, 20)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("net.Listen", err)
+	}
+	server := NewCacheServer(&CacheServerOptions{
+		StoreOptions: cba.StoreOptions{Dir: dir + "/server"},
+		Secret:       secret,
+	})
+	go server.serve(newWorkerListener(l, secret))
+	defer l.Close()
+
+	newClient := func(name string) *actionCache {
+		store := cba.NewStore(&cba.StoreOptions{Dir: dir + "/" + name}, nil)
+		c := newActionCache(store, dir+"/"+name+"-actions")
+		c.remote = newRemoteActionCache(l.Addr().String(), newWorkerDialer(secret), store)
+		return c
+	}
+	a := newClient("a")
+	b := newClient("b")
+
+	output := a.store.Save([]byte("output"))
+	key := a.hash("key")
+	want := cachedAction{Stdout: "hello"}
+	blobs := []ActionBlob{{Hash: output, Size: int64(len("output"))}}
+	content, _ := json.Marshal(&want)
+	h := a.store.Save(content)
+	a.set(key, h)
+	a.remote.put(&ActionPutRequest{
+		Key:        key,
+		ActionBlob: ActionBlob{Hash: h, Size: int64(len(content))},
+		Blobs:      append(blobs, ActionBlob{Hash: h, Size: int64(len(content))}),
+	})
+
+	var got cachedAction
+	if !b.getJSON(key, &got) {
+		t.Fatalf("getJSON on other master failed")
+	}
+	if got.Stdout != want.Stdout {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+	if !b.have(output, int64(len("output"))) || !b.store.Has(output) {
+		t.Errorf("output blob not fetched from server")
+	}
+	if b.getLocal(key) != h {
+		t.Errorf("remote hit not stored locally")
+	}
+}
@@ -0,0 +1,100 @@
+package termite
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/hanwen/termite/cba"
+)
+
+// Number of recent directories, binaries and dependencies to
+// remember per worker.
+const affinityHistory = 256
+
+// Declared dependencies beyond this many are not looked at, to keep
+// scheduling cheap.
+const maxAffinityDeps = 64
+
+// affinityKeys returns what a task has in common with related tasks:
+// directory, binary and declared dependencies. Tasks sharing keys
+// likely read the same files, so they run faster on the same worker.
+func affinityKeys(req *WorkRequest) []string {
+	keys := []string{"dir:" + req.Dir, "bin:" + req.Binary}
+	for i, d := range req.DeclaredDeps {
+		if i >= maxAffinityDeps {
+			break
+		}
+		if d != "" {
+			keys = append(keys, "dep:"+filepath.Join(req.Dir, d))
+		}
+	}
+	return keys
+}
+
+// taskAffinity describes where the inputs of a task are likely
+// cached.
+type taskAffinity struct {
+	keys []string
+
+	// Number of input contents known to be on each worker.
+	contentCount map[string]int
+}
+
+// affinity computes the affinity of a task. It uses the attribute
+// cache, so it must be called without holding mirrorConnections.Mutex.
+func (m *Master) affinity(req *WorkRequest) *taskAffinity {
+	a := &taskAffinity{
+		keys:         affinityKeys(req),
+		contentCount: map[string]int{},
+	}
+	for _, k := range a.keys {
+		if !strings.HasPrefix(k, "dep:") {
+			continue
+		}
+		f := m.attributes.Get(strings.TrimLeft(k[len("dep:"):], "/"))
+		if f == nil || !f.IsRegular() || f.Hash == "" {
+			continue
+		}
+		for _, addr := range m.locations.get(f.Hash) {
+			a.contentCount[addr]++
+		}
+	}
+	return a
+}
+
+// recentTasks remembers the affinity keys of tasks that ran on a
+// worker. Protected by mirrorConnections.Mutex.
+type recentTasks struct {
+	keys *cba.LruCache
+}
+
+func newRecentTasks() *recentTasks {
+	return &recentTasks{keys: cba.NewLruCache(affinityHistory)}
+}
+
+func (r *recentTasks) add(keys []string) {
+	if r == nil {
+		return
+	}
+	for _, k := range keys {
+		if r.keys.Get(k) == nil {
+			r.keys.Add(k, true)
+		}
+	}
+}
+
+func (r *recentTasks) has(key string) bool {
+	return r != nil && r.keys.Has(key)
+}
+
+// score returns how much a task with the given affinity would
+// benefit from running on mc.
+func (a *taskAffinity) score(mc *mirrorConnection) int {
+	s := a.contentCount[mc.workerAddr]
+	for _, k := range a.keys {
+		if mc.recent.has(k) {
+			s++
+		}
+	}
+	return s
+}
@@ -0,0 +1,35 @@
+package termite
+
+import (
+	"testing"
+)
+
+func TestMirrorConnectionsPickAffinity(t *testing.T) {
+	a := &mirrorConnection{workerAddr: "a", maxJobs: 2, availableJobs: 2, recent: newRecentTasks()}
+	b := &mirrorConnection{workerAddr: "b", maxJobs: 2, availableJobs: 1, recent: newRecentTasks()}
+	c := &mirrorConnections{
+		mirrors: map[string]*mirrorConnection{"a": a, "b": b},
+	}
+
+	libReq := &WorkRequest{Dir: "/src/lib", Binary: "/usr/bin/cc", DeclaredDeps: []string{"lib.h"}}
+	aff := &taskAffinity{keys: affinityKeys(libReq), contentCount: map[string]int{}}
+
+	// Without history, the least loaded mirror wins.
+	if got, _ := c.pick(aff, nil); got != a {
+		t.Fatalf("got %s, want a", got.workerAddr)
+	}
+	b.availableJobs++
+
+	// Related tasks go to the same worker, even if it is busier.
+	related := &WorkRequest{Dir: "/src/lib", Binary: "/usr/bin/cc", DeclaredDeps: []string{"lib.c"}}
+	if got, _ := c.pick(&taskAffinity{keys: affinityKeys(related)}, nil); got != a {
+		t.Fatalf("got %s, want a", got.workerAddr)
+	}
+	a.availableJobs++
+
+	// Known content outweighs recent tasks.
+	aff.contentCount["b"] = 5
+	if got, _ := c.pick(aff, nil); got != b {
+		t.Errorf("got %s, want b", got.workerAddr)
+	}
+}
@@ -3,6 +3,7 @@ package termite
 import (
 	"crypto"
 	_ "crypto/md5"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -15,8 +16,68 @@ import (
 	"github.com/hanwen/termite/analyze"
 )
 
+// newTiming returns the phase that started at start, relative to the
+// task that started at base.
+func newTiming(name string, base, start time.Time) Timing {
+	return Timing{
+		Name:  name,
+		Start: start.Sub(base).Seconds(),
+		Dt:    time.Since(start).Seconds(),
+	}
+}
+
+// readInputs returns the hash and modification time of the files
+// read by a task, as they were when it was sent to the worker. The
+// paths are relative to the writable root; files outside it, and
+// files the task wrote itself, are skipped.
+func (m *Master) readInputs(rep *WorkResponse) []analyze.Input {
+	wrRoot := strings.TrimLeft(m.options.WritableRoot, "/")
+	written := map[string]bool{}
+	if rep.FileSet != nil {
+		for _, f := range rep.FileSet.Files {
+			written[strings.TrimPrefix(f.Path, wrRoot+"/")] = true
+		}
+	}
+	var rel []string
+	for _, r := range rep.Reads {
+		if !strings.HasPrefix(r, "/") && !written[r] {
+			rel = append(rel, r)
+		}
+	}
+	sort.Strings(rel)
+
+	// Tasks that didn't run on a worker saw the current state.
+	var attrs attrGetter = m.attributes
+	if rep.inputs != nil {
+		attrs = rep.inputs
+	}
+	hashes, _ := m.inputHashes(attrs, rel)
+	var inputs []analyze.Input
+	for i, r := range rel {
+		in := analyze.Input{Path: r, Hash: hashes[i]}
+		if a := attrs.Get(filepath.Join(wrRoot, r)); a.Attr != nil {
+			in.ModTime = a.ModTime()
+		}
+		inputs = append(inputs, in)
+	}
+	return inputs
+}
+
+// envHashes returns the hash of the value of each variable in env.
+func envHashes(env []string) map[string]string {
+	r := map[string]string{}
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) < 2 {
+			continue
+		}
+		r[kv[0]] = fmt.Sprintf("%x", sha256.Sum256([]byte(kv[1])))
+	}
+	return r
+}
+
 func DumpAnnotations(req *WorkRequest, rep *WorkResponse, start time.Time,
-	outDir string, topDir string) {
+	outDir string, topDir string, inputs []analyze.Input) {
 	dur := time.Since(start)
 	a := analyze.Command{
 		Deps:    req.DeclaredDeps,
@@ -24,6 +85,7 @@ func DumpAnnotations(req *WorkRequest, rep *WorkResponse, start time.Time,
 		Target:  req.DeclaredTarget,
 		Reads:   rep.Reads,
 		Command: strings.Join(req.Argv, " "),
+		Argv:    req.Argv,
 	}
 
 	slashTopDir := topDir + "/"
@@ -57,10 +119,20 @@ func DumpAnnotations(req *WorkRequest, rep *WorkResponse, start time.Time,
 	h.Write(out)
 	fn := fmt.Sprintf("%x", h.Sum(nil))
 
-	// Don't hash timestamps.
+	// Don't hash timestamps, or where the command ran.
 	a.Time = time.Now()
 	a.Duration = dur
 	a.Filename = fn
+	a.Worker = rep.WorkerId
+	a.EnvHashes = envHashes(req.Env)
+	a.Inputs = inputs
+	for _, t := range rep.Timings {
+		a.Phases = append(a.Phases, analyze.Phase{
+			Name:     t.Name,
+			Start:    time.Duration(t.Start * float64(time.Second)),
+			Duration: time.Duration(t.Dt * float64(time.Second)),
+		})
+	}
 
 	out, err = json.Marshal(&a)
 	if err != nil {
@@ -0,0 +1,71 @@
+package termite
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/termite/attr"
+)
+
+func TestReadInputs(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	getattr := func(n string) *attr.FileAttr {
+		a := &attr.FileAttr{Path: n}
+		if fi, _ := os.Lstat("/" + n); fi != nil {
+			a.Attr = fuse.ToAttr(fi)
+			a.ReadFromFs("/"+n, crypto.MD5)
+		}
+		return a
+	}
+	m := &Master{
+		options: &MasterOptions{WritableRoot: dir},
+		attributes: attr.NewAttributeCache(getattr, func(n string) *fuse.Attr {
+			fi, _ := os.Lstat("/" + n)
+			return fuse.ToAttr(fi)
+		}),
+	}
+
+	name := strings.TrimLeft(dir, "/")
+	if err := ioutil.WriteFile(dir+"/a.c", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := m.attributes.Get(name + "/a.c")
+	f := m.attributes.Freeze()
+	defer f.Release()
+
+	if err := ioutil.WriteFile(dir+"/a.c", []byte("bb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.attributes.RefreshNames([]string{name + "/a.c"})
+
+	rep := &WorkResponse{
+		Reads:   []string{"a.c", "a.o", "/etc/passwd"},
+		FileSet: &attr.FileSet{Files: []*attr.FileAttr{{Path: name + "/a.o"}}},
+		inputs:  f,
+	}
+	inputs := m.readInputs(rep)
+	if len(inputs) != 1 || inputs[0].Path != "a.c" {
+		t.Fatalf("got %v, want only a.c", inputs)
+	}
+	if inputs[0].Hash != before.Hash {
+		t.Errorf("got hash %x, want %x from when the task started", inputs[0].Hash, before.Hash)
+	}
+}
+
+func TestEnvHashes(t *testing.T) {
+	got := envHashes([]string{"TOKEN=secret", "EMPTY=", "BROKEN"})
+	if len(got) != 2 || got["TOKEN"] == "" || got["EMPTY"] == "" || got["TOKEN"] == got["EMPTY"] {
+		t.Errorf("got %v, want distinct hashes for TOKEN and EMPTY", got)
+	}
+	for _, v := range got {
+		if strings.Contains(v, "secret") {
+			t.Errorf("value stored: %v", got)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package termite
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/hanwen/termite/stats"
+)
+
+// The types below are served as JSON under /api/ on the HTTP status
+// ports. Fields are only ever added, so scripts keep working.
+
+// MasterStatus is served on the master's /api/status.
+type MasterStatus struct {
+	Version      string
+	WritableRoot string
+	SourceRoot   string
+
+	// Job slots: -jobs, reserved on workers, and reserved but
+	// unused.
+	WantedJobs    int
+	ReservedJobs  int
+	AvailableJobs int
+
+	// Workers we have a mirror on.
+	Mirrors []string
+
+	PhaseCounts map[string]int
+	Timings     map[string]*stats.RpcTiming
+
+	// Finished tasks by outcome.
+	Tasks map[string]int64
+
+	AttributeCacheFiles int
+}
+
+// TaskStatus describes a task running on the master, on
+// /api/tasks.
+type TaskStatus struct {
+	Id        int
+	ClientId  string
+	Argv      []string
+	Dir       string
+	Started   time.Time
+	Cancelled bool
+
+	// Workers running the task.
+	Workers []string
+}
+
+type byTaskId []TaskStatus
+
+func (s byTaskId) Len() int           { return len(s) }
+func (s byTaskId) Less(i, j int) bool { return s[i].Id < s[j].Id }
+func (s byTaskId) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// MasterShareStatus describes a master sharing the workers, on the
+// coordinator's /api/masters.
+type MasterShareStatus struct {
+	Master string
+	User   string
+	Wanted int
+	Active bool
+	Share  int
+	Grants map[string]int
+}
+
+func serveJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Println("json.Marshal:", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func (m *Master) apiStatus() *MasterStatus {
+	s := &MasterStatus{
+		Version:             Version(),
+		WritableRoot:        m.options.WritableRoot,
+		SourceRoot:          m.options.SourceRoot,
+		PhaseCounts:         map[string]int{},
+		Timings:             m.timing.Timings(),
+		Tasks:               m.tasks.Counts(),
+		AttributeCacheFiles: m.attributes.Len(),
+		Mirrors:             []string{},
+	}
+	counts := m.mirrors.stats.PhaseCounts()
+	for i, n := range m.mirrors.stats.PhaseOrder {
+		s.PhaseCounts[n] = counts[i]
+	}
+
+	c := m.mirrors
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	s.WantedJobs = c.wantedMaxJobs
+	s.ReservedJobs = c.maxJobs(nil)
+	s.AvailableJobs = c.availableJobs(nil)
+	for addr := range c.mirrors {
+		s.Mirrors = append(s.Mirrors, addr)
+	}
+	sort.Strings(s.Mirrors)
+	return s
+}
+
+func (w *Worker) apiStatus() *WorkerStatusResponse {
+	rep := &WorkerStatusResponse{}
+	w.Status(&WorkerStatusRequest{}, rep)
+	return rep
+}
+
+// apiWorkers returns the registered workers, sorted by address.
+func (c *Coordinator) apiWorkers() []WorkerRegistration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	keys := []string{}
+	for k := range c.workers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := []WorkerRegistration{}
+	for _, k := range keys {
+		out = append(out, *c.workers[k])
+	}
+	return out
+}
+
+func (c *Coordinator) apiMasters() []MasterShareStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ids := []string{}
+	for id := range c.masters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	out := []MasterShareStatus{}
+	for _, id := range ids {
+		m := c.masters[id]
+		out = append(out, MasterShareStatus{
+			Master: id,
+			User:   m.User,
+			Wanted: m.Wanted,
+			Active: m.Active,
+			Share:  m.share,
+			Grants: m.grants,
+		})
+	}
+	return out
+}
+
+// apiClient fetches status from workers, which may hang.
+var apiClient = &http.Client{Timeout: 10 * time.Second}
+
+// apiWorkerHandler serves the /api/status of a worker.
+func (c *Coordinator) apiWorkerHandler(w http.ResponseWriter, req *http.Request) {
+	worker, err := c.getHostWorker(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	host, _, _ := net.SplitHostPort(worker.Address)
+	resp, err := apiClient.Get(fmt.Sprintf("http://%s:%d/api/status", host, worker.HttpStatusPort))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,84 @@
+package termite
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestRunningTasksStatus(t *testing.T) {
+	r := newRunningTasks()
+	r.add(&WorkRequest{TaskId: 2, ClientId: "c", Argv: []string{"touch", "b"}})
+	r.add(&WorkRequest{TaskId: 1, ClientId: "c", Argv: []string{"touch", "a"}, Dir: "/src"})
+	r.running(1, &mirrorConnection{workerAddr: "w2"})
+	r.running(1, &mirrorConnection{workerAddr: "w1"})
+
+	got := r.status()
+	if len(got) != 2 || got[0].Id != 1 || got[1].Id != 2 {
+		t.Fatalf("got %v, want tasks 1 and 2", got)
+	}
+	if got[0].Dir != "/src" || !reflect.DeepEqual(got[0].Argv, []string{"touch", "a"}) {
+		t.Errorf("got %v", got[0])
+	}
+	if want := []string{"w1", "w2"}; !reflect.DeepEqual(got[0].Workers, want) {
+		t.Errorf("got workers %v, want %v", got[0].Workers, want)
+	}
+}
+
+func TestCoordinatorApiWorkers(t *testing.T) {
+	c := NewCoordinator(&CoordinatorOptions{})
+	c.workers["w2"] = &WorkerRegistration{Registration: Registration{Address: "w2", Name: "two"}}
+	c.workers["w1"] = &WorkerRegistration{Registration: Registration{Address: "w1", Name: "one",
+		Labels: Labels{"pool": "bigmem"}}}
+
+	rec := httptest.NewRecorder()
+	serveJSON(rec, c.apiWorkers())
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+
+	var got []WorkerRegistration
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if len(got) != 2 || got[0].Address != "w1" || got[1].Address != "w2" {
+		t.Fatalf("got %v, want w1 and w2", got)
+	}
+	if got[0].Labels["pool"] != "bigmem" {
+		t.Errorf("got labels %v", got[0].Labels)
+	}
+}
+
+func TestCoordinatorApiWorker(t *testing.T) {
+	status := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/status" {
+			http.NotFound(w, req)
+			return
+		}
+		serveJSON(w, &WorkerStatusResponse{MaxJobCount: 3})
+	}))
+	defer status.Close()
+	_, port, _ := net.SplitHostPort(status.Listener.Addr().String())
+	httpPort, _ := strconv.Atoi(port)
+
+	c := NewCoordinator(&CoordinatorOptions{})
+	c.workers["127.0.0.1:1"] = &WorkerRegistration{Registration: Registration{
+		Address: "127.0.0.1:1", HttpStatusPort: httpPort}}
+
+	rec := httptest.NewRecorder()
+	c.apiWorkerHandler(rec, httptest.NewRequest("GET", "/api/worker?host=unknown:1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown worker: got status %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	c.apiWorkerHandler(rec, httptest.NewRequest("GET", "/api/worker?host=127.0.0.1:1", nil))
+	var got WorkerStatusResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || got.MaxJobCount != 3 {
+		t.Errorf("got %v %v, want the worker's status", got, err)
+	}
+}
@@ -0,0 +1,98 @@
+package termite
+
+import (
+	"bufio"
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/hanwen/termite/attr"
+)
+
+// attributeSnapshotPath returns where to keep the snapshot of the
+// attribute cache. The name depends on the options that determine
+// what the master serves, so a snapshot is never reused with
+// different ones.
+func (m *Master) attributeSnapshotPath() string {
+	excludes := append([]string{}, m.options.Excludes...)
+	sort.Strings(excludes)
+
+	h := md5.New()
+	fmt.Fprintf(h, "%q %q %v %d %q %q %q", m.options.WritableRoot, m.options.SourceRoot,
+		m.options.ExposePrivate, m.options.Uid, m.options.Socket, m.options.LogFile, excludes)
+	return filepath.Join(m.options.StoreOptions.Dir, "attributes", fmt.Sprintf("%x", h.Sum(nil)))
+}
+
+// loadAttributes fills the attribute cache from the snapshot, and
+// refreshes entries that changed since it was written.
+func (m *Master) loadAttributes() {
+	start := time.Now()
+	f, err := os.Open(m.attributeSnapshot)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("loadAttributes: %v", err)
+		return
+	}
+	defer f.Close()
+
+	fs, err := attr.ReadSnapshot(bufio.NewReader(f))
+	if err != nil {
+		log.Printf("Ignoring attribute snapshot %s: %v", m.attributeSnapshot, err)
+		return
+	}
+
+	// Content may have been dropped from the store since. Files
+	// left out are fetched again on demand.
+	files := fs.Files[:0]
+	for _, a := range fs.Files {
+		if a.IsRegular() && !m.contentStore.Has(a.Hash) {
+			continue
+		}
+		files = append(files, a)
+	}
+	m.attributes.Update(files)
+	changed := m.attributes.Refresh("")
+	m.timing.Log("Master.LoadAttributes", time.Now().Sub(start))
+	log.Printf("Loaded %d attributes from snapshot, %d changed since, in %v",
+		len(files), len(changed.Files), time.Now().Sub(start))
+}
+
+// saveAttributes writes a snapshot of the attribute cache.
+func (m *Master) saveAttributes() {
+	start := time.Now()
+	dir := filepath.Dir(m.attributeSnapshot)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("saveAttributes: %v", err)
+		return
+	}
+	f, err := ioutil.TempFile(dir, ".snapshot")
+	if err != nil {
+		log.Printf("saveAttributes: %v", err)
+		return
+	}
+
+	w := bufio.NewWriter(f)
+	err = m.attributes.WriteSnapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), m.attributeSnapshot)
+	}
+	if err != nil {
+		log.Printf("saveAttributes: %v", err)
+		os.Remove(f.Name())
+		return
+	}
+	m.timing.Log("Master.SaveAttributes", time.Now().Sub(start))
+}
@@ -0,0 +1,180 @@
+package termite
+
+import (
+	"io"
+	"log"
+	"net/rpc"
+	"sync"
+
+	"github.com/hanwen/termite/cba"
+)
+
+// remoteActionCache is the connection from a master to a shared
+// CacheServer.
+type remoteActionCache struct {
+	addr   string
+	dialer connDialer
+	store  *cba.Store
+
+	// Protects the connection fields; the clients themselves
+	// are safe for concurrent use.
+	mu             sync.Mutex
+	rpcClient      *rpc.Client
+	contentClient  *cba.Client
+	revContentConn io.ReadWriteCloser
+}
+
+func newRemoteActionCache(addr string, dialer connDialer, store *cba.Store) *remoteActionCache {
+	return &remoteActionCache{
+		addr:   addr,
+		dialer: dialer,
+		store:  store,
+	}
+}
+
+// connect sets up the connection to the server. Must hold mutex.
+func (r *remoteActionCache) connect() error {
+	if r.rpcClient != nil {
+		return nil
+	}
+
+	closeMe := []io.ReadWriteCloser{}
+	defer func() {
+		for _, c := range closeMe {
+			c.Close()
+		}
+	}()
+	mux, err := r.dialer.Dial(r.addr)
+	if err != nil {
+		return err
+	}
+
+	conn, err := mux.Open(RPC_CHANNEL)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := CacheConnectRequest{
+		RpcId:        ConnectionId(),
+		ContentId:    ConnectionId(),
+		RevContentId: ConnectionId(),
+	}
+	conns := map[string]io.ReadWriteCloser{}
+	for _, id := range []string{req.RpcId, req.ContentId, req.RevContentId} {
+		c, err := mux.Open(id)
+		if err != nil {
+			return err
+		}
+		closeMe = append(closeMe, c)
+		conns[id] = c
+	}
+
+	rep := CacheConnectResponse{}
+	cl := rpc.NewClient(conn)
+	err = cl.Call("CacheServer.Connect", &req, &rep)
+	cl.Close()
+	if err != nil {
+		return err
+	}
+	closeMe = nil
+
+	go r.store.ServeConn(conns[req.RevContentId])
+	r.rpcClient = rpc.NewClient(conns[req.RpcId])
+	r.contentClient = r.store.NewClient(conns[req.ContentId])
+	r.revContentConn = conns[req.RevContentId]
+	log.Printf("Connected to cache server %s", r.addr)
+	return nil
+}
+
+// clients returns the connection to the server, connecting if
+// needed.
+func (r *remoteActionCache) clients() (*rpc.Client, *cba.Client, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.connect(); err != nil {
+		return nil, nil, err
+	}
+	return r.rpcClient, r.contentClient, nil
+}
+
+// drop closes the connection that failed with err, so the next call
+// reconnects.
+func (r *remoteActionCache) drop(cl *rpc.Client, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.rpcClient == nil || r.rpcClient != cl {
+		// Already replaced by another call.
+		return
+	}
+	log.Printf("Dropping connection to cache server %s: %v", r.addr, err)
+	r.rpcClient.Close()
+	r.contentClient.Close()
+	r.revContentConn.Close()
+	r.rpcClient = nil
+	r.contentClient = nil
+	r.revContentConn = nil
+}
+
+// get returns the hash stored under key, making sure its content is
+// in the local store. Returns "" if the key is unknown or the server
+// is unreachable.
+func (r *remoteActionCache) get(key string) string {
+	cl, content, err := r.clients()
+	if err != nil {
+		log.Printf("cache server %s: %v", r.addr, err)
+		return ""
+	}
+
+	req := ActionGetRequest{Key: key}
+	rep := ActionGetResponse{}
+	if err := cl.Call("ActionCache.Get", &req, &rep); err != nil {
+		r.drop(cl, err)
+		return ""
+	}
+	if rep.Hash == "" || !r.fetch(cl, content, rep.Hash, rep.Size) {
+		return ""
+	}
+	return rep.Hash
+}
+
+// fetch makes sure the given content is in the local store.
+func (r *remoteActionCache) fetch(cl *rpc.Client, content *cba.Client, hash string, size int64) bool {
+	if r.store.Has(hash) {
+		return true
+	}
+	got, err := content.FetchOnce(hash, size)
+	if err != nil {
+		r.drop(cl, err)
+		return false
+	}
+	return got
+}
+
+// have returns whether content is available locally, fetching it
+// from the server if needed.
+func (r *remoteActionCache) have(hash string, size int64) bool {
+	if r.store.Has(hash) {
+		return true
+	}
+
+	cl, content, err := r.clients()
+	if err != nil {
+		return false
+	}
+	return r.fetch(cl, content, hash, size)
+}
+
+// put stores a key on the server, uploading the blobs it needs.
+func (r *remoteActionCache) put(req *ActionPutRequest) {
+	cl, _, err := r.clients()
+	if err != nil {
+		log.Printf("cache server %s: %v", r.addr, err)
+		return
+	}
+
+	rep := ActionPutResponse{}
+	if err := cl.Call("ActionCache.Put", req, &rep); err != nil {
+		r.drop(cl, err)
+	}
+}
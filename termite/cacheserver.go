@@ -0,0 +1,144 @@
+package termite
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/rpc"
+	"os"
+	"path/filepath"
+
+	"github.com/hanwen/termite/cba"
+)
+
+// CacheServer stores content and action results on behalf of
+// multiple masters, so results computed for one developer can be
+// replayed by another.
+type CacheServer struct {
+	options  *CacheServerOptions
+	listener connListener
+	store    *cba.Store
+	actions  *actionCache
+}
+
+type CacheServerOptions struct {
+	cba.StoreOptions
+
+	Secret []byte
+
+	// (starting) port to listen to.
+	Port int
+
+	// How many other ports try.
+	PortRetry int
+}
+
+func NewCacheServer(options *CacheServerOptions) *CacheServer {
+	o := *options
+	s := &CacheServer{
+		options: &o,
+	}
+	s.store = cba.NewStore(&o.StoreOptions, nil)
+	s.actions = newActionCache(s.store, filepath.Join(o.StoreOptions.Dir, "actions"))
+	return s
+}
+
+// RPC interface for CacheServer.
+type CacheService CacheServer
+
+func (cs *CacheService) Connect(req *CacheConnectRequest, rep *CacheConnectResponse) error {
+	return ((*CacheServer)(cs)).Connect(req, rep)
+}
+
+func (s *CacheServer) Run() {
+	listener := portRangeListener(s.options.Port, s.options.PortRetry)
+	s.serve(newWorkerListener(listener, s.options.Secret))
+}
+
+func (s *CacheServer) serve(listener connListener) {
+	s.listener = listener
+	rs := rpc.NewServer()
+	if err := rs.RegisterName("CacheServer", (*CacheService)(s)); err != nil {
+		log.Printf("RegisterName(%T): %v", s, err)
+		return
+	}
+	for c := range s.listener.Pending().rpcChan() {
+		go rs.ServeConn(c)
+	}
+}
+
+func (s *CacheServer) Connect(req *CacheConnectRequest, rep *CacheConnectResponse) error {
+	pending := s.listener.Pending()
+	rpcConn := pending.accept(req.RpcId)
+	contentConn := pending.accept(req.ContentId)
+	revContentConn := pending.accept(req.RevContentId)
+	if rpcConn == nil || contentConn == nil || revContentConn == nil {
+		for _, c := range []io.ReadWriteCloser{rpcConn, contentConn, revContentConn} {
+			if c != nil {
+				c.Close()
+			}
+		}
+		return fmt.Errorf("cache server is shutting down")
+	}
+
+	conn := &cacheConnection{
+		server:        s,
+		contentClient: s.store.NewClient(revContentConn),
+	}
+	go conn.serve(rpcConn, contentConn)
+	return nil
+}
+
+// cacheConnection serves the action cache to a single master.
+type cacheConnection struct {
+	server *CacheServer
+
+	// For fetching uploads from the master.
+	contentClient *cba.Client
+}
+
+func (c *cacheConnection) serve(rpcConn, contentConn io.ReadWriteCloser) {
+	server := rpc.NewServer()
+	server.RegisterName("ActionCache", c)
+	done := make(chan int, 2)
+	go func() {
+		server.ServeConn(rpcConn)
+		done <- 1
+	}()
+	go func() {
+		c.server.store.ServeConn(contentConn)
+		done <- 1
+	}()
+	<-done
+	rpcConn.Close()
+	contentConn.Close()
+	c.contentClient.Close()
+}
+
+func (c *cacheConnection) Get(req *ActionGetRequest, rep *ActionGetResponse) error {
+	h := c.server.actions.get(req.Key)
+	if h == "" {
+		return nil
+	}
+	fi, err := os.Lstat(c.server.store.Path(h))
+	if err != nil {
+		return nil
+	}
+	rep.Hash = h
+	rep.Size = fi.Size()
+	return nil
+}
+
+func (c *cacheConnection) Put(req *ActionPutRequest, rep *ActionPutResponse) error {
+	for _, b := range req.Blobs {
+		got, err := c.contentClient.FetchOnce(b.Hash, b.Size)
+		if err != nil {
+			return err
+		}
+		if !got {
+			return fmt.Errorf("master does not have %x", b.Hash)
+		}
+	}
+	c.server.actions.set(req.Key, req.Hash)
+	return nil
+}
@@ -0,0 +1,242 @@
+package termite
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hanwen/termite/attr"
+)
+
+var errCancelled = errors.New("task cancelled")
+var errTimedOut = errors.New("task timed out")
+
+type runningTask struct {
+	taskId   int
+	clientId string
+	argv     []string
+	dir      string
+	labels   string
+	started  time.Time
+
+	// Workers the task was sent to, with whether that attempt was
+	// cancelled. There is more than one for speculative duplicates.
+	attempts  map[*mirrorConnection]bool
+	cancelled bool
+
+	// Attribute views of the attempts, released when the task is
+	// done.
+	views []*attr.Frozen
+}
+
+// runningTasks tracks the tasks the master is running, so they can
+// be cancelled.
+type runningTasks struct {
+	mutex    sync.Mutex
+	tasks    map[int]*runningTask
+	byClient map[string]*runningTask
+}
+
+func newRunningTasks() *runningTasks {
+	return &runningTasks{
+		tasks:    map[int]*runningTask{},
+		byClient: map[string]*runningTask{},
+	}
+}
+
+func (r *runningTasks) add(req *WorkRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := &runningTask{
+		taskId:   req.TaskId,
+		clientId: req.ClientId,
+		argv:     req.Argv,
+		dir:      req.Dir,
+		labels:   req.Labels.String(),
+		started:  time.Now(),
+		attempts: map[*mirrorConnection]bool{},
+	}
+	r.tasks[t.taskId] = t
+	if t.clientId != "" {
+		r.byClient[t.clientId] = t
+	}
+}
+
+// started returns when the task started, or the zero time for
+// unknown tasks.
+func (r *runningTasks) started(taskId int) time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if t := r.tasks[taskId]; t != nil {
+		return t.started
+	}
+	return time.Time{}
+}
+
+// waiting returns how many tasks needing workers with exactly the
+// labels of selector are not running on a worker yet.
+func (r *runningTasks) waiting(selector Labels) int {
+	key := selector.String()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	n := 0
+	for _, t := range r.tasks {
+		if t.labels == key && len(t.attempts) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// addView keeps the view until the task is removed. It returns
+// false for unknown tasks.
+func (r *runningTasks) addView(taskId int, v *attr.Frozen) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.tasks[taskId]
+	if t == nil {
+		return false
+	}
+	t.views = append(t.views, v)
+	return true
+}
+
+func (r *runningTasks) remove(taskId int) {
+	r.mutex.Lock()
+	t := r.tasks[taskId]
+	if t == nil {
+		r.mutex.Unlock()
+		return
+	}
+	delete(r.tasks, taskId)
+	if r.byClient[t.clientId] == t {
+		delete(r.byClient, t.clientId)
+	}
+	r.mutex.Unlock()
+
+	for _, v := range t.views {
+		v.Release()
+	}
+}
+
+// running records that the task is sent to mc. It returns false if
+// the task was cancelled.
+func (r *runningTasks) running(taskId int, mc *mirrorConnection) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.tasks[taskId]
+	if t == nil {
+		return true
+	}
+	if t.cancelled {
+		return false
+	}
+	t.attempts[mc] = false
+	return true
+}
+
+// finished records that the attempt on mc returned, and returns
+// whether it was cancelled. If the attempt produced a result and was
+// not cancelled, its result is used, and the other attempts are
+// cancelled, before any of their results are replayed.
+func (r *runningTasks) finished(taskId int, mc *mirrorConnection, succeeded bool) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.tasks[taskId]
+	if t == nil {
+		return false
+	}
+	cancelled := t.attempts[mc] || t.cancelled
+	delete(t.attempts, mc)
+	if succeeded && !cancelled {
+		for other := range t.attempts {
+			r.cancelAttemptLocked(t, other)
+		}
+	}
+	return cancelled
+}
+
+func (r *runningTasks) cancelled(taskId int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.tasks[taskId]
+	return t != nil && t.cancelled
+}
+
+// Must hold lock.
+func (r *runningTasks) cancelTask(t *runningTask) {
+	if t.cancelled {
+		return
+	}
+	t.cancelled = true
+	for mc := range t.attempts {
+		r.cancelAttemptLocked(t, mc)
+	}
+}
+
+// Must hold lock.
+func (r *runningTasks) cancelAttemptLocked(t *runningTask, mc *mirrorConnection) {
+	if cancelled, ok := t.attempts[mc]; !ok || cancelled {
+		return
+	}
+	t.attempts[mc] = true
+	go func(id int) {
+		req := CancelRequest{TaskId: id}
+		rep := CancelResponse{}
+		if err := mc.rpcClient.Call("Mirror.Cancel", &req, &rep); err != nil {
+			log.Printf("Cancelling task %d on %s: %v", id, mc.workerAddr, err)
+		}
+	}(t.taskId)
+}
+
+// cancel cancels the task the wrapper knows as clientId. It returns
+// whether there was such a task.
+func (r *runningTasks) cancel(clientId string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	t := r.byClient[clientId]
+	if t == nil {
+		return false
+	}
+	log.Printf("Cancelling task %d", t.taskId)
+	r.cancelTask(t)
+	return true
+}
+
+// cancelAll cancels all running tasks, and returns how many there
+// were.
+func (r *runningTasks) cancelAll() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, t := range r.tasks {
+		r.cancelTask(t)
+	}
+	log.Printf("Cancelled %d tasks", len(r.tasks))
+	return len(r.tasks)
+}
+
+// status describes the running tasks, oldest first.
+func (r *runningTasks) status() []TaskStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	out := []TaskStatus{}
+	for _, t := range r.tasks {
+		s := TaskStatus{
+			Id:        t.taskId,
+			ClientId:  t.clientId,
+			Argv:      t.argv,
+			Dir:       t.dir,
+			Started:   t.started,
+			Cancelled: t.cancelled,
+		}
+		for mc := range t.attempts {
+			s.Workers = append(s.Workers, mc.workerAddr)
+		}
+		sort.Strings(s.Workers)
+		out = append(out, s)
+	}
+	sort.Sort(byTaskId(out))
+	return out
+}
@@ -0,0 +1,113 @@
+package termite
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+
+	"github.com/hanwen/termite/attr"
+	"github.com/hanwen/termite/cba"
+)
+
+func TestRunningTasksCancel(t *testing.T) {
+	r := newRunningTasks()
+	r.add(&WorkRequest{TaskId: 1, ClientId: "a"})
+	r.add(&WorkRequest{TaskId: 2})
+
+	if r.cancel("b") {
+		t.Errorf("cancelled unknown client")
+	}
+	if !r.running(1, nil) || r.finished(1, nil, false) {
+		t.Errorf("task 1 cancelled before cancel")
+	}
+	if !r.cancel("a") || !r.cancelled(1) || r.cancelled(2) {
+		t.Errorf("cancel a: got %v %v", r.cancelled(1), r.cancelled(2))
+	}
+	if r.running(1, nil) {
+		t.Errorf("cancelled task may run")
+	}
+
+	r.remove(1)
+	if r.cancel("a") {
+		t.Errorf("cancelled removed task")
+	}
+	if n := r.cancelAll(); n != 1 || !r.cancelled(2) {
+		t.Errorf("cancelAll: got %d, cancelled %v", n, r.cancelled(2))
+	}
+}
+
+func TestFileSetWaiterDiscard(t *testing.T) {
+	w := attr.NewFileSetWaiter(func(attr.FileSet) error { return nil })
+	w.Prepare(1)
+	w.Prepare(2)
+
+	// Task 2 reaped the file system, and learned the results were
+	// discarded.
+	w.Discard([]int{1, 2}, 2)
+	if err := w.Wait(nil, nil, 1); err != attr.ErrDiscarded {
+		t.Errorf("got %v, want %v", err, attr.ErrDiscarded)
+	}
+}
+
+func TestMirrorLateCancel(t *testing.T) {
+	m := &Mirror{
+		activeFses: map[*workerFSState]bool{},
+		cancelled:  map[int]bool{},
+		finished:   cba.NewLruCache(10),
+	}
+	m.cond = sync.NewCond(&m.fsMutex)
+
+	// Cancelled before it arrived.
+	m.Cancel(&CancelRequest{TaskId: 1}, &CancelResponse{})
+	if !m.cancelled[1] {
+		t.Errorf("early cancel not recorded")
+	}
+	m.taskDone(1)
+
+	// Cancelled after it finished.
+	m.taskDone(2)
+	m.Cancel(&CancelRequest{TaskId: 2}, &CancelResponse{})
+	if len(m.cancelled) != 0 {
+		t.Errorf("cancellations left: %v", m.cancelled)
+	}
+}
+
+func TestRunningTasksFirstAttemptWins(t *testing.T) {
+	// Cancellations to the workers fail right away.
+	a, b := net.Pipe()
+	b.Close()
+	cl := rpc.NewClient(a)
+	defer cl.Close()
+	orig := &mirrorConnection{workerAddr: "orig", rpcClient: cl}
+	dup := &mirrorConnection{workerAddr: "dup", rpcClient: cl}
+
+	r := newRunningTasks()
+	r.add(&WorkRequest{TaskId: 1})
+	r.running(1, orig)
+	r.running(1, dup)
+
+	if r.finished(1, dup, true) {
+		t.Errorf("winner cancelled")
+	}
+	if !r.finished(1, orig, true) {
+		t.Errorf("loser not cancelled")
+	}
+}
+
+func TestRunningTasksWaiting(t *testing.T) {
+	r := newRunningTasks()
+	big := Labels{"pool": "bigmem"}
+	r.add(&WorkRequest{TaskId: 1, Labels: big})
+	r.add(&WorkRequest{TaskId: 2, Labels: Labels{"pool": "bigmem"}})
+	r.add(&WorkRequest{TaskId: 3, Labels: big})
+	r.add(&WorkRequest{TaskId: 4})
+
+	r.running(3, &mirrorConnection{})
+	if got := r.waiting(big); got != 2 {
+		t.Errorf("got %d waiting bigmem tasks, want 2", got)
+	}
+	if got := r.waiting(nil); got != 1 {
+		t.Errorf("got %d waiting unlabelled tasks, want 1", got)
+	}
+}
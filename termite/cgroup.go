@@ -0,0 +1,158 @@
+package termite
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ResourceLimits restricts the resources a task may use. Zero fields
+// mean no limit.
+type ResourceLimits struct {
+	// Memory in bytes.
+	Memory int64
+
+	// Number of CPUs; may be fractional.
+	Cpus float64
+
+	// Number of processes and threads.
+	Pids int
+}
+
+func (l ResourceLimits) empty() bool {
+	return l.Memory <= 0 && l.Cpus <= 0 && l.Pids <= 0
+}
+
+// merge returns l, with the unset fields taken from defaults.
+func (l ResourceLimits) merge(defaults ResourceLimits) ResourceLimits {
+	if l.Memory <= 0 {
+		l.Memory = defaults.Memory
+	}
+	if l.Cpus <= 0 {
+		l.Cpus = defaults.Cpus
+	}
+	if l.Pids <= 0 {
+		l.Pids = defaults.Pids
+	}
+	return l
+}
+
+// Period for the CPU bandwidth controller, in microseconds.
+const cpuPeriod = 100000
+
+// setupCgroupRoot prepares a cgroup v2 directory to hold the cgroups
+// of tasks. The worker itself must live outside of it, as cgroups
+// with controllers enabled for their children can't hold processes.
+func setupCgroupRoot(root string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	return writeCgroupFile(root, "cgroup.subtree_control", "+memory +cpu +pids")
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// taskCgroup is the cgroup that a single task runs in.
+type taskCgroup struct {
+	dir string
+}
+
+// newTaskCgroup creates a cgroup with the given limits under root.
+// The task should be started inside it, so none of its processes
+// escape the limits.
+func newTaskCgroup(root string, limits ResourceLimits) (*taskCgroup, error) {
+	dir, err := ioutil.TempDir(root, "task-")
+	if err != nil {
+		return nil, err
+	}
+	cg := &taskCgroup{dir: dir}
+
+	var settings [][2]string
+	if limits.Memory > 0 {
+		settings = append(settings,
+			[2]string{"memory.max", strconv.FormatInt(limits.Memory, 10)},
+			// Kill the whole task, rather than some random child.
+			[2]string{"memory.oom.group", "1"})
+	}
+	if limits.Cpus > 0 {
+		settings = append(settings, [2]string{"cpu.max",
+			fmt.Sprintf("%d %d", int64(limits.Cpus*cpuPeriod), cpuPeriod)})
+	}
+	if limits.Pids > 0 {
+		settings = append(settings, [2]string{"pids.max", strconv.Itoa(limits.Pids)})
+	}
+
+	for _, s := range settings {
+		if err := writeCgroupFile(cg.dir, s[0], s[1]); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	return cg, nil
+}
+
+// setProcAttr makes a process started with attr start in the cgroup.
+// It returns the directory to close once the process started.
+func (cg *taskCgroup) setProcAttr(attr *syscall.SysProcAttr) (*os.File, error) {
+	f, err := os.Open(cg.dir)
+	if err != nil {
+		return nil, err
+	}
+	attr.UseCgroupFD = true
+	attr.CgroupFD = int(f.Fd())
+	return f, nil
+}
+
+// kill kills all processes in the cgroup.
+func (cg *taskCgroup) kill() error {
+	// Not supported before Linux 5.14.
+	return writeCgroupFile(cg.dir, "cgroup.kill", "1")
+}
+
+// oomKilled returns whether processes in the cgroup were killed for
+// going over the memory limit.
+func (cg *taskCgroup) oomKilled() bool {
+	f, err := os.Open(filepath.Join(cg.dir, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "oom_kill" {
+			n, _ := strconv.Atoi(fields[1])
+			return n > 0
+		}
+	}
+	return false
+}
+
+// remove kills leftover processes, and removes the cgroup.
+func (cg *taskCgroup) remove() {
+	// If kill is not supported, leftovers keep us from removing
+	// the cgroup.
+	cg.kill()
+
+	var err error
+	for i := 0; i < 10; i++ {
+		// Killed processes take a moment to leave.
+		if err = syscall.Rmdir(cg.dir); err != syscall.EBUSY {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		log.Printf("removing cgroup %s: %v", cg.dir, err)
+	}
+}
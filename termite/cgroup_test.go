@@ -0,0 +1,64 @@
+package termite
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestResourceLimitsMerge(t *testing.T) {
+	defaults := ResourceLimits{Memory: 1 << 30, Cpus: 1, Pids: 100}
+	got := ResourceLimits{Memory: 4 << 30}.merge(defaults)
+	want := ResourceLimits{Memory: 4 << 30, Cpus: 1, Pids: 100}
+	if got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if !(ResourceLimits{}).merge(ResourceLimits{}).empty() {
+		t.Errorf("merge of empty limits is not empty")
+	}
+}
+
+func TestCgroupOomKilled(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	cg := &taskCgroup{dir: dir}
+	if cg.oomKilled() {
+		t.Errorf("oomKilled without memory.events")
+	}
+	ioutil.WriteFile(dir+"/memory.events", []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 0\n"), 0644)
+	if cg.oomKilled() {
+		t.Errorf("oomKilled with oom_kill 0")
+	}
+	ioutil.WriteFile(dir+"/memory.events", []byte("low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"), 0644)
+	if !cg.oomKilled() {
+		t.Errorf("oom_kill not detected")
+	}
+}
+
+func TestNewTaskCgroup(t *testing.T) {
+	root, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(root)
+
+	cg, err := newTaskCgroup(root, ResourceLimits{Pids: 10})
+	if err != nil {
+		t.Fatalf("newTaskCgroup: %v", err)
+	}
+	if content, _ := ioutil.ReadFile(cg.dir + "/pids.max"); string(content) != "10" {
+		t.Errorf("pids.max: got %q", content)
+	}
+	if _, err := os.Stat(cg.dir + "/cgroup.procs"); err == nil {
+		t.Errorf("process moved into cgroup after creation")
+	}
+
+	attr := &syscall.SysProcAttr{}
+	dir, err := cg.setProcAttr(attr)
+	if err != nil {
+		t.Fatalf("setProcAttr: %v", err)
+	}
+	defer dir.Close()
+	if !attr.UseCgroupFD || attr.CgroupFD != int(dir.Fd()) {
+		t.Errorf("got %+v, want start in cgroup", attr)
+	}
+}
@@ -43,6 +43,12 @@ func ConnectionId() string {
 	return string(encoded)
 }
 
+// RandomConnectionId returns an id for a connection that is unique
+// across processes.
+func RandomConnectionId() string {
+	return "r" + string(RandomBytes(HEADER_LEN-1))
+}
+
 func OpenSocketConnection(socket string, channel string, timeout time.Duration) net.Conn {
 	delay := time.Duration(0)
 	conn, err := net.Dial("unix", socket)
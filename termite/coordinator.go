@@ -19,6 +19,17 @@ type Registration struct {
 	Name           string
 	Version        string
 	HttpStatusPort int
+	Labels         Labels
+
+	// Number of job slots.
+	Jobs int
+
+	// Set if the worker finishes its tasks, but takes no new
+	// ones.
+	Draining bool
+
+	// Filled in by the coordinator.
+	Load WorkerLoad
 }
 
 type RegistrationRequest Registration
@@ -27,6 +38,9 @@ type ListRequest struct {
 	// Return changes after this time stamp.  Will halt if no
 	// changes to report.
 	Latest time.Time
+
+	// Only return workers with these labels.
+	Selector Labels
 }
 
 type ListResponse struct {
@@ -34,6 +48,34 @@ type ListResponse struct {
 	LastChange    time.Time
 }
 
+// AllocateRequest describes the job slots a master holds and wants.
+type AllocateRequest struct {
+	// Unique for the lifetime of the master.
+	Master string
+	User   string
+
+	// Slots the master would like.
+	Wanted int
+
+	// Whether the master ran tasks recently. Masters that didn't
+	// only keep the slots that are busy.
+	Active bool
+
+	// Slots reserved and in use, by worker. Busy slots beyond
+	// the grant are not given to others until they are released.
+	Held map[string]int
+	Busy map[string]int
+
+	// Only workers with these labels.
+	Selector Labels
+}
+
+type AllocateResponse struct {
+	// Slots the master may hold, by worker. Mirrors holding more
+	// should give back the difference.
+	Grants map[string]int
+}
+
 type WorkerRegistration struct {
 	Registration
 	LastReported time.Time
@@ -55,6 +97,9 @@ type Coordinator struct {
 	cond       *sync.Cond
 	workers    map[string]*WorkerRegistration
 	lastChange time.Time
+
+	// Masters sharing the workers, if fair sharing is on.
+	masters map[string]*masterShare
 }
 
 // RPC interface for Coordinator
@@ -68,6 +113,10 @@ func (cs *CoordinatorService) List(req *ListRequest, rep *ListResponse) error {
 	return ((*Coordinator)(cs)).List(req, rep)
 }
 
+func (cs *CoordinatorService) Allocate(req *AllocateRequest, rep *AllocateResponse) error {
+	return ((*Coordinator)(cs)).Allocate(req, rep)
+}
+
 type CoordinatorOptions struct {
 	// Secret is the password for coordinator, workers and master
 	// to authenticate.
@@ -76,6 +125,15 @@ type CoordinatorOptions struct {
 	// Password should be passed in the kill/restart URLs to make
 	// sure web scrapers don't randomly shutdown workers.
 	WebPassword string
+
+	// If set, the coordinator decides how many job slots each
+	// master may reserve, splitting them fairly between users
+	// and their masters.
+	FairShare bool
+
+	// Maximum slots per master and per user; 0 means no limit.
+	MasterQuota int
+	UserQuota   int
 }
 
 func NewCoordinator(opts *CoordinatorOptions) *Coordinator {
@@ -83,6 +141,7 @@ func NewCoordinator(opts *CoordinatorOptions) *Coordinator {
 	c := &Coordinator{
 		options: &o,
 		workers: make(map[string]*WorkerRegistration),
+		masters: make(map[string]*masterShare),
 		Mux:     http.NewServeMux(),
 		dialer:  newWorkerDialer(o.Secret),
 	}
@@ -103,6 +162,9 @@ func (c *Coordinator) Register(req *RegistrationRequest, rep *Empty) error {
 	defer c.mutex.Unlock()
 
 	w := &WorkerRegistration{Registration: Registration(*req)}
+	if old := c.workers[w.Address]; old != nil {
+		w.Load = old.Load
+	}
 	w.LastReported = time.Now()
 	c.lastChange = w.LastReported
 	c.workers[w.Address] = w
@@ -130,6 +192,9 @@ func (c *Coordinator) List(req *ListRequest, rep *ListResponse) error {
 	sort.Strings(keys)
 	for _, k := range keys {
 		w := c.workers[k]
+		if w.Draining || !w.Labels.Matches(req.Selector) {
+			continue
+		}
 		rep.Registrations = append(rep.Registrations, w.Registration)
 	}
 	rep.LastChange = c.lastChange
@@ -176,9 +241,79 @@ func (c *Coordinator) checkReachable() {
 	c.mutex.Unlock()
 }
 
+// How long to wait for the status of a worker.
+const loadTimeout = 5 * time.Second
+
+// refreshLoads fetches the status of all workers, so masters can
+// choose the least loaded ones. Masters see the new loads on their
+// next List; they are not woken for it.
+func (c *Coordinator) refreshLoads() {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	loads := map[string]WorkerLoad{}
+	for _, a := range c.workerAddresses() {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			conn, err := c.dialWorker(addr)
+			if err != nil {
+				return
+			}
+			// Unblocks the call if the worker hangs.
+			timer := time.AfterFunc(loadTimeout, func() { conn.Close() })
+			defer timer.Stop()
+			cl := rpc.NewClient(conn)
+			defer cl.Close()
+			req := WorkerStatusRequest{}
+			rep := WorkerStatusResponse{}
+			if err := cl.Call("Worker.Status", &req, &rep); err != nil {
+				log.Printf("Worker.Status(%s): %v", addr, err)
+				return
+			}
+			mu.Lock()
+			if loads != nil {
+				loads[addr] = loadFromStatus(&rep)
+			}
+			mu.Unlock()
+		}(a)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(loadTimeout):
+		log.Printf("Not all workers sent their status within %v", loadTimeout)
+	}
+	mu.Lock()
+	got := loads
+	loads = nil
+	mu.Unlock()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for a, l := range got {
+		if w := c.workers[a]; w != nil {
+			w.Load = l
+		}
+	}
+}
+
 const _POLL = 60
 
+// How often to fetch the load of the workers.
+const _LOAD_POLL = 10 * time.Second
+
 func (c *Coordinator) PeriodicCheck() {
+	go func() {
+		for {
+			time.Sleep(_LOAD_POLL)
+			c.refreshLoads()
+		}
+	}()
 	for {
 		time.Sleep(_POLL * time.Second)
 		c.checkReachable()
@@ -212,6 +347,32 @@ func (c *Coordinator) shutdownWorker(addr string, restart bool) error {
 	return err
 }
 
+// drainWorker makes the worker finish its tasks without taking new
+// ones, and hides it from the masters.
+func (c *Coordinator) drainWorker(addr string, shutdown, restart bool) (*DrainResponse, error) {
+	conn, err := c.dialWorker(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	req := DrainRequest{Shutdown: shutdown, Restart: restart}
+	rep := DrainResponse{}
+	cl := rpc.NewClient(conn)
+	defer cl.Close()
+	if err := cl.Call("Worker.Drain", &req, &rep); err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if w := c.workers[addr]; w != nil {
+		w.Draining = true
+		c.lastChange = time.Now()
+		c.cond.Broadcast()
+	}
+	return &rep, nil
+}
+
 func (c *Coordinator) workerAddresses() (out []string) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -13,17 +13,27 @@ import (
 )
 
 func (c *Coordinator) getHost(req *http.Request) (string, error) {
+	w, err := c.getHostWorker(req)
+	if err != nil {
+		return "", err
+	}
+	return w.Address, nil
+}
+
+// getHostWorker returns the registration of the worker named by the
+// 'host' query parameter.
+func (c *Coordinator) getHostWorker(req *http.Request) (*WorkerRegistration, error) {
 	q := req.URL.Query()
 	vs, ok := q["host"]
 	if !ok || len(vs) == 0 {
-		return "", fmt.Errorf("query param 'host' missing")
+		return nil, fmt.Errorf("query param 'host' missing")
 	}
 	addr := string(vs[0])
-	if c.getWorker(addr) == nil {
-		return "", fmt.Errorf("worker %q unknown", addr)
+	w := c.getWorker(addr)
+	if w == nil {
+		return nil, fmt.Errorf("worker %q unknown", addr)
 	}
-
-	return addr, nil
+	return w, nil
 }
 
 func (c *Coordinator) workerHandler(w http.ResponseWriter, req *http.Request) {
@@ -71,6 +81,26 @@ func (c *Coordinator) ServeHTTP(port int) {
 		func(w http.ResponseWriter, req *http.Request) {
 			c.killHandler(w, req)
 		})
+	c.Mux.HandleFunc("/drain",
+		func(w http.ResponseWriter, req *http.Request) {
+			c.drainHandler(w, req)
+		})
+	c.Mux.HandleFunc("/metrics",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveMetrics(w, c.writeMetrics)
+		})
+	c.Mux.HandleFunc("/api/workers",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveJSON(w, c.apiWorkers())
+		})
+	c.Mux.HandleFunc("/api/worker",
+		func(w http.ResponseWriter, req *http.Request) {
+			c.apiWorkerHandler(w, req)
+		})
+	c.Mux.HandleFunc("/api/masters",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveJSON(w, c.apiMasters())
+		})
 	c.Mux.HandleFunc("/killall",
 		func(w http.ResponseWriter, req *http.Request) {
 			c.killAllHandler(w, req)
@@ -194,6 +224,38 @@ func (c *Coordinator) killHandler(w http.ResponseWriter, req *http.Request) {
 	go c.checkReachable()
 }
 
+func (c *Coordinator) drainHandler(w http.ResponseWriter, req *http.Request) {
+	c.log(req)
+	if !c.checkPassword(w, req) {
+		return
+	}
+
+	addr, err := c.getHost(req)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "<html><head><title>Termite worker error</title></head>")
+		fmt.Fprintf(w, "<body>Error: %s</body></html>", err.Error())
+		return
+	}
+
+	then := req.URL.Query().Get("then")
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprintf(w, "<html><head><title>Termite worker status</title></head>")
+	fmt.Fprintf(w, "<body><h1>Status %s</h1>", addr)
+	defer fmt.Fprintf(w, "</body></html>")
+
+	rep, err := c.drainWorker(addr, then == "shutdown" || then == "restart", then == "restart")
+	if err != nil {
+		fmt.Fprintf(w, "<p><tt>Error: %v<tt>", err)
+		return
+	}
+	fmt.Fprintf(w, "<p>drain of %s in progress, %d tasks running", addr, rep.Running)
+	if then != "" {
+		fmt.Fprintf(w, "; will %s when done", then)
+	}
+	fmt.Fprintf(w, "<p><a href=\"/\">back to index</a>")
+}
+
 func (c *Coordinator) rootHandler(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 	c.mutex.Lock()
@@ -215,11 +277,38 @@ func (c *Coordinator) rootHandler(w http.ResponseWriter, req *http.Request) {
 		addr := worker.Address
 		fmt.Fprintf(w, "<li><a href=\"worker?host=%s\">address <tt>%s</tt>, host <tt>%s</tt></a>"+
 			" (<a href=\"/workerkill?host=%s\">Kill</a>, \n"+
-			"<a href=\"/restart?host=%s\">Restart</a>)\n",
-			addr, addr, worker.Name, addr, addr)
+			"<a href=\"/restart?host=%s\">Restart</a>, \n"+
+			"<a href=\"/drain?host=%s\">Drain</a>, \n"+
+			"<a href=\"/drain?host=%s&then=restart\">Drain and restart</a>)\n",
+			addr, addr, worker.Name, addr, addr, addr, addr)
+		if len(worker.Labels) > 0 {
+			fmt.Fprintf(w, "<br>labels: <tt>%s</tt>\n", worker.Labels)
+		}
+		if worker.Draining {
+			fmt.Fprintf(w, " <b>draining</b>\n")
+		}
+		if l := worker.Load; !l.Updated.IsZero() {
+			fmt.Fprintf(w, "<br>load: %.0f%% cpu, %v available, %d/%d jobs\n",
+				100*l.Cpu, l.MemAvailable, l.Jobs, l.MaxJobs)
+		}
 	}
 	fmt.Fprintf(w, "</ul>")
 
+	if len(c.masters) > 0 {
+		ids := []string{}
+		for id := range c.masters {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprintf(w, "<h2>Masters</h2><ul>")
+		for _, id := range ids {
+			m := c.masters[id]
+			fmt.Fprintf(w, "<li><tt>%s</tt>, user <tt>%s</tt>: wants %d, share %d, granted %v\n",
+				id, m.User, m.Wanted, m.share, m.grants)
+		}
+		fmt.Fprintf(w, "</ul>")
+	}
+
 	fmt.Fprintf(w, "<hr><p><a href=\"killall\">kill all workers,</a>"+
 		"<a href=\"restartall\">restart all workers</a>")
 }
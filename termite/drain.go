@@ -0,0 +1,74 @@
+package termite
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// ErrDraining is returned for new work while the worker is draining.
+// The master should run the task elsewhere.
+var ErrDraining = errors.New("worker is draining")
+
+// isDrainingError returns whether err is ErrDraining. Errors that
+// passed through RPC lose their identity, so we compare the message.
+func isDrainingError(err error) bool {
+	return err != nil && err.Error() == ErrDraining.Error()
+}
+
+// How often to check whether a draining worker is done.
+const drainPoll = time.Second
+
+func (w *Worker) isDraining() bool {
+	w.drainMutex.Lock()
+	defer w.drainMutex.Unlock()
+	return w.draining
+}
+
+// Drain lets the running tasks finish, but refuses new mirrors and
+// tasks. Once done, the worker optionally shuts down.
+func (w *Worker) Drain(req *DrainRequest, rep *DrainResponse) error {
+	log.Printf("Received Drain RPC: %#v", req)
+	w.drainMutex.Lock()
+	already := w.draining
+	w.draining = true
+	w.drainMutex.Unlock()
+
+	// Tasks waiting for a job slot should give up.
+	w.mirrors.wakeWaiters()
+	rep.Running = w.mirrors.runningCount()
+	if already {
+		return nil
+	}
+
+	go func() {
+		// Get out of the worker list quickly.
+		w.Report()
+		for w.mirrors.runningCount() > 0 {
+			time.Sleep(drainPoll)
+		}
+		log.Println("Drained all tasks.")
+		if req.Shutdown {
+			w.shutdown(req.Restart, false)
+		}
+	}()
+	return nil
+}
+
+func (wm *WorkerMirrors) runningCount() int {
+	r := 0
+	for _, m := range wm.mirrors() {
+		m.fsMutex.Lock()
+		r += m.runningCount()
+		m.fsMutex.Unlock()
+	}
+	return r
+}
+
+func (wm *WorkerMirrors) wakeWaiters() {
+	for _, m := range wm.mirrors() {
+		m.fsMutex.Lock()
+		m.cond.Broadcast()
+		m.fsMutex.Unlock()
+	}
+}
@@ -0,0 +1,52 @@
+package termite
+
+import (
+	"net/rpc"
+	"testing"
+	"time"
+)
+
+func TestWorkerDrain(t *testing.T) {
+	w := &Worker{
+		options:   &WorkerOptions{},
+		accepting: true,
+	}
+	w.mirrors = NewWorkerMirrors(w)
+
+	rep := DrainResponse{}
+	if err := w.Drain(&DrainRequest{}, &rep); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if rep.Running != 0 {
+		t.Errorf("got %d running tasks, want 0", rep.Running)
+	}
+
+	err := w.CreateMirror(&CreateMirrorRequest{MaxJobCount: 1}, &CreateMirrorResponse{})
+	if err != ErrDraining {
+		t.Errorf("CreateMirror while draining: got %v, want %v", err, ErrDraining)
+	}
+	// Errors lose their identity over RPC.
+	if !isDrainingError(rpc.ServerError(err.Error())) {
+		t.Errorf("RPC error %v not recognized", err)
+	}
+	if isDrainingError(nil) || isDrainingError(ShuttingDownError) {
+		t.Errorf("isDrainingError matches other errors")
+	}
+}
+
+func TestCoordinatorListHidesDraining(t *testing.T) {
+	c := NewCoordinator(&CoordinatorOptions{})
+	for _, a := range []string{"a:1", "b:1"} {
+		c.workers[a] = &WorkerRegistration{Registration: Registration{Address: a}}
+	}
+	c.workers["b:1"].Draining = true
+	c.lastChange = time.Now()
+
+	rep := ListResponse{}
+	if err := c.List(&ListRequest{}, &rep); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(rep.Registrations) != 1 || rep.Registrations[0].Address != "a:1" {
+		t.Errorf("got %v, want only a:1", rep.Registrations)
+	}
+}
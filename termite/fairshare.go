@@ -0,0 +1,380 @@
+package termite
+
+import (
+	"errors"
+	"log"
+	"net/rpc"
+	"sort"
+	"time"
+)
+
+// Masters that did not ask for slots for this long are forgotten.
+const allocationExpiry = time.Minute
+
+// How often masters ask for slots.
+const allocationPeriod = 10 * time.Second
+
+// How long a task waits for slots, if the coordinator gave us none.
+const allocationWait = time.Minute
+
+// masterShare is the coordinator's view of a master.
+type masterShare struct {
+	AllocateRequest
+	updated time.Time
+
+	share  int
+	grants map[string]int
+}
+
+// demand returns how many slots the master could use. Idle masters
+// only need the slots that are still running tasks.
+func (m *masterShare) demand() int {
+	if m.Active {
+		return m.Wanted
+	}
+	d := 0
+	for _, n := range m.Busy {
+		d += n
+	}
+	return d
+}
+
+func (w *WorkerRegistration) jobs() int {
+	if w.Jobs > 0 {
+		return w.Jobs
+	}
+	if w.Load.MaxJobs > 0 {
+		return w.Load.MaxJobs
+	}
+	return 1
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// waterFill divides total between the demands: nobody gets more than
+// they asked for, and the rest is split evenly.
+func waterFill(total int, demands map[string]int) map[string]int {
+	out := map[string]int{}
+	keys := sortedKeys(demands)
+	for total > 0 {
+		progress := false
+		for _, k := range keys {
+			if total > 0 && out[k] < demands[k] {
+				out[k]++
+				total--
+				progress = true
+			}
+		}
+		if !progress {
+			break
+		}
+	}
+	return out
+}
+
+// fairShare computes how many of the slots each master may hold.
+// Slots are split between users first, and then between the masters
+// of each user. Zero quotas mean no limit.
+func fairShare(capacity int, masters map[string]*masterShare, masterQuota, userQuota int) map[string]int {
+	demand := map[string]int{}
+	userDemand := map[string]int{}
+	for id, m := range masters {
+		d := m.demand()
+		if masterQuota > 0 && d > masterQuota {
+			d = masterQuota
+		}
+		demand[id] = d
+		userDemand[m.User] += d
+	}
+	for u, d := range userDemand {
+		if userQuota > 0 && d > userQuota {
+			userDemand[u] = userQuota
+		}
+	}
+
+	share := map[string]int{}
+	for u, s := range waterFill(capacity, userDemand) {
+		ds := map[string]int{}
+		for id, m := range masters {
+			if m.User == u {
+				ds[id] = demand[id]
+			}
+		}
+		for id, n := range waterFill(s, ds) {
+			share[id] = n
+		}
+	}
+	return share
+}
+
+// assignSlots hands out slots on workers according to the shares.
+// Masters keep what they hold as far as their share allows, so the
+// caches on their workers stay warm. The rest goes to masters below
+// their share, on workers they don't use yet.
+func assignSlots(workers map[string]*WorkerRegistration, masters map[string]*masterShare, share map[string]int) {
+	free := map[string]int{}
+	for a, w := range workers {
+		if !w.Draining {
+			free[a] = w.jobs()
+		}
+	}
+	eligible := func(m *masterShare, addr string) bool {
+		w := workers[addr]
+		return w != nil && !w.Draining && w.Labels.Matches(m.Selector)
+	}
+
+	ids := []string{}
+	for id := range masters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	left := map[string]int{}
+	for _, id := range ids {
+		m := masters[id]
+		m.share = share[id]
+		m.grants = map[string]int{}
+		left[id] = share[id]
+		for _, a := range sortedKeys(m.Held) {
+			n := m.Held[a]
+			if n > left[id] {
+				n = left[id]
+			}
+			if n > free[a] {
+				n = free[a]
+			}
+			if n <= 0 || !eligible(m, a) {
+				continue
+			}
+			m.grants[a] = n
+			left[id] -= n
+			free[a] -= n
+		}
+	}
+
+	// Busy slots beyond the grant stay taken until their tasks
+	// finish.
+	for _, id := range ids {
+		m := masters[id]
+		for a, n := range m.Busy {
+			if extra := n - m.grants[a]; extra > 0 {
+				free[a] -= extra
+			}
+		}
+	}
+
+	addrs := sortedKeys(free)
+	for _, id := range ids {
+		m := masters[id]
+		for left[id] > 0 {
+			best := ""
+			for _, a := range addrs {
+				if free[a] <= 0 || m.Held[a] > 0 || m.grants[a] > 0 || !eligible(m, a) {
+					continue
+				}
+				if best == "" || free[a] > free[best] {
+					best = a
+				}
+			}
+			if best == "" {
+				break
+			}
+			n := left[id]
+			if n > free[best] {
+				n = free[best]
+			}
+			m.grants[best] = n
+			left[id] -= n
+			free[best] -= n
+		}
+	}
+}
+
+// Allocate records what a master holds and wants, and returns the
+// slots it may hold.
+func (c *Coordinator) Allocate(req *AllocateRequest, rep *AllocateResponse) error {
+	if !c.options.FairShare {
+		return errors.New("fair sharing is disabled")
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	for id, m := range c.masters {
+		if m.updated.Add(allocationExpiry).Before(now) {
+			delete(c.masters, id)
+		}
+	}
+	m := c.masters[req.Master]
+	if m == nil {
+		m = &masterShare{}
+		c.masters[req.Master] = m
+	}
+	m.AllocateRequest = *req
+	m.updated = now
+
+	capacity := 0
+	for _, w := range c.workers {
+		if !w.Draining {
+			capacity += w.jobs()
+		}
+	}
+	share := fairShare(capacity, c.masters, c.options.MasterQuota, c.options.UserQuota)
+	assignSlots(c.workers, c.masters, share)
+
+	rep.Grants = m.grants
+	return nil
+}
+
+// allocationRequest describes what we hold and want. Must hold lock.
+func (c *mirrorConnections) allocationRequest() *AllocateRequest {
+	req := &AllocateRequest{
+		Master:   c.masterId,
+		User:     c.user,
+		Wanted:   c.wantedMaxJobs,
+		Active:   c.lastActionTime.Add(c.keepAlive).After(time.Now()),
+		Held:     map[string]int{},
+		Busy:     map[string]int{},
+		Selector: c.selector,
+	}
+	for addr, mc := range c.mirrors {
+		req.Held[addr] += mc.maxJobs
+		if busy := mc.maxJobs - mc.availableJobs; busy > 0 {
+			req.Busy[addr] += busy
+		}
+	}
+	// The worker keeps all slots of a retired mirror until it
+	// closes, so they count as busy.
+	for mc := range c.retired {
+		req.Held[mc.workerAddr] += mc.maxJobs
+		req.Busy[mc.workerAddr] += mc.maxJobs
+	}
+	return req
+}
+
+// retiredJobs returns the slots taken by retired mirrors on the
+// worker. Must hold lock.
+func (c *mirrorConnections) retiredJobs(addr string) int {
+	n := 0
+	for mc := range c.retired {
+		if mc.workerAddr == addr {
+			n += mc.maxJobs
+		}
+	}
+	return n
+}
+
+// applyGrants shrinks or retires the mirrors that hold more slots
+// than granted. Must hold lock.
+func (c *mirrorConnections) applyGrants(grants map[string]int) {
+	c.grants = grants
+	for addr, mc := range c.mirrors {
+		allowed := grants[addr] - c.retiredJobs(addr)
+		if allowed <= 0 {
+			c.retireLocked(mc, "slots reclaimed by the coordinator")
+		} else if allowed < mc.maxJobs {
+			c.shrinkLocked(mc, allowed)
+		}
+	}
+}
+
+// shrinkLocked gives back the slots of mc beyond jobs. Tasks running
+// in them finish. Must hold lock.
+func (c *mirrorConnections) shrinkLocked(mc *mirrorConnection, jobs int) {
+	log.Printf("Shrinking mirror %s from %d to %d jobs: slots reclaimed by the coordinator",
+		mc.workerAddr, mc.maxJobs, jobs)
+	mc.availableJobs -= mc.maxJobs - jobs
+	mc.maxJobs = jobs
+	go func() {
+		req := ShrinkRequest{MaxJobCount: jobs}
+		rep := ShrinkResponse{}
+		if err := mc.rpcClient.Call("Mirror.Shrink", &req, &rep); err != nil {
+			log.Printf("Mirror.Shrink(%s): %v", mc.workerAddr, err)
+		}
+	}()
+}
+
+// allocate tells the coordinator what we hold and want, and gives
+// back the slots beyond what it granted. It returns false if the
+// coordinator doesn't broker slots.
+func (c *mirrorConnections) allocate() bool {
+	c.Mutex.Lock()
+	req := c.allocationRequest()
+	c.Mutex.Unlock()
+
+	rep := AllocateResponse{}
+	client, err := rpc.DialHTTP("tcp", c.coordinator)
+	if err == nil {
+		err = client.Call("Coordinator.Allocate", req, &rep)
+		client.Close()
+	}
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if err != nil {
+		if c.grants != nil {
+			log.Println("Coordinator.Allocate:", err)
+		}
+		c.grants = nil
+		return false
+	}
+	if rep.Grants == nil {
+		rep.Grants = map[string]int{}
+	}
+	c.applyGrants(rep.Grants)
+	return true
+}
+
+func (c *mirrorConnections) periodicAllocate() {
+	for {
+		c.allocate()
+		time.Sleep(allocationPeriod)
+	}
+}
+
+// connectGranted creates mirrors for the slots the coordinator gave
+// us. Must hold lock.
+func (c *mirrorConnections) connectGranted(selector Labels) {
+	for _, addr := range sortedKeys(c.grants) {
+		// Retired mirrors on the worker still use part of the
+		// grant.
+		n := c.grants[addr] - c.retiredJobs(addr)
+		if _, ok := c.mirrors[addr]; ok || n <= 0 || !c.matches(addr, selector) {
+			continue
+		}
+		if err := c.connect(addr, n); err != nil {
+			// Its slots may not be released yet; the next
+			// allocation tells us more.
+			delete(c.grants, addr)
+			log.Println("nonfatal error creating mirror:", err)
+		}
+	}
+}
+
+// waitForGrants asks the coordinator for slots until we get some.
+// Other masters release slots as their tasks finish, so this may take
+// a while. Must hold lock.
+func (c *mirrorConnections) waitForGrants(selector Labels) {
+	deadline := time.Now().Add(allocationWait)
+	for c.maxJobs(selector) == 0 && c.grants != nil && time.Now().Before(deadline) {
+		// Makes us count as active.
+		c.lastActionTime = time.Now()
+		c.Mutex.Unlock()
+		c.allocate()
+		c.Mutex.Lock()
+		c.tryConnect(selector)
+		if c.maxJobs(selector) == 0 {
+			c.Mutex.Unlock()
+			time.Sleep(time.Second)
+			c.Mutex.Lock()
+		}
+	}
+}
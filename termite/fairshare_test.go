@@ -0,0 +1,143 @@
+package termite
+
+import (
+	"net"
+	"net/rpc"
+	"reflect"
+	"testing"
+)
+
+func TestWaterFill(t *testing.T) {
+	got := waterFill(10, map[string]int{"a": 2, "b": 20, "c": 20})
+	want := map[string]int{"a": 2, "b": 4, "c": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	got = waterFill(10, map[string]int{"a": 1, "b": 2})
+	want = map[string]int{"a": 1, "b": 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestFairShare(t *testing.T) {
+	masters := map[string]*masterShare{
+		"alice1": {AllocateRequest: AllocateRequest{User: "alice", Wanted: 20, Active: true}},
+		"alice2": {AllocateRequest: AllocateRequest{User: "alice", Wanted: 20, Active: true}},
+		"bob":    {AllocateRequest: AllocateRequest{User: "bob", Wanted: 20, Active: true}},
+		// Idle, only keeps its busy slots.
+		"carol": {AllocateRequest: AllocateRequest{User: "carol", Wanted: 20,
+			Busy: map[string]int{"w1": 1}}},
+	}
+	got := fairShare(21, masters, 0, 0)
+	want := map[string]int{"alice1": 5, "alice2": 5, "bob": 10, "carol": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got = fairShare(21, masters, 0, 6)
+	want = map[string]int{"alice1": 3, "alice2": 3, "bob": 6, "carol": 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("with user quota: got %v, want %v", got, want)
+	}
+}
+
+func TestAssignSlots(t *testing.T) {
+	workers := map[string]*WorkerRegistration{
+		"w1": {Registration: Registration{Address: "w1", Jobs: 4}},
+		"w2": {Registration: Registration{Address: "w2", Jobs: 4}},
+		"big": {Registration: Registration{Address: "big", Jobs: 2,
+			Labels: Labels{"pool": "bigmem"}}},
+	}
+	masters := map[string]*masterShare{
+		// Holds all of w1, but must give up half.
+		"a": {AllocateRequest: AllocateRequest{Held: map[string]int{"w1": 4}}},
+		"b": {AllocateRequest: AllocateRequest{Selector: Labels{"pool": "bigmem"}}},
+		"c": {},
+	}
+	assignSlots(workers, masters, map[string]int{"a": 2, "b": 3, "c": 5})
+
+	if want := map[string]int{"w1": 2}; !reflect.DeepEqual(masters["a"].grants, want) {
+		t.Errorf("a: got %v, want %v", masters["a"].grants, want)
+	}
+	if want := map[string]int{"big": 2}; !reflect.DeepEqual(masters["b"].grants, want) {
+		t.Errorf("b: got %v, want %v", masters["b"].grants, want)
+	}
+	if want := map[string]int{"w2": 4, "w1": 1}; !reflect.DeepEqual(masters["c"].grants, want) {
+		t.Errorf("c: got %v, want %v", masters["c"].grants, want)
+	}
+}
+
+func TestCoordinatorAllocate(t *testing.T) {
+	c := NewCoordinator(&CoordinatorOptions{})
+	c.workers["w1"] = &WorkerRegistration{Registration: Registration{Address: "w1", Jobs: 4}}
+	req := AllocateRequest{Master: "a", User: "alice", Wanted: 10, Active: true}
+	if err := c.Allocate(&req, &AllocateResponse{}); err == nil {
+		t.Errorf("Allocate should fail without fair sharing")
+	}
+
+	c.options.FairShare = true
+	rep := AllocateResponse{}
+	if err := c.Allocate(&req, &rep); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if rep.Grants["w1"] != 4 {
+		t.Errorf("got %v, want all of w1", rep.Grants)
+	}
+
+	// A second master gets half of the slots.
+	req2 := AllocateRequest{Master: "b", User: "bob", Wanted: 10, Active: true}
+	rep = AllocateResponse{}
+	if err := c.Allocate(&req2, &rep); err != nil {
+		t.Fatalf("Allocate: %v", err)
+	}
+	if rep.Grants["w1"] != 2 {
+		t.Errorf("got %v, want 2 slots on w1", rep.Grants)
+	}
+}
+
+func TestAssignSlotsKeepsBusy(t *testing.T) {
+	workers := map[string]*WorkerRegistration{
+		"w1": {Registration: Registration{Address: "w1", Jobs: 4}},
+	}
+	masters := map[string]*masterShare{
+		// Must give up w1, but still runs 3 tasks there.
+		"a": {AllocateRequest: AllocateRequest{
+			Held: map[string]int{"w1": 4},
+			Busy: map[string]int{"w1": 3},
+		}},
+		"b": {},
+	}
+	assignSlots(workers, masters, map[string]int{"a": 0, "b": 4})
+	if want := map[string]int{"w1": 1}; !reflect.DeepEqual(masters["b"].grants, want) {
+		t.Errorf("b: got %v, want %v", masters["b"].grants, want)
+	}
+}
+
+func TestMirrorConnectionsApplyGrants(t *testing.T) {
+	// Shrink requests to the worker fail right away.
+	a, b := net.Pipe()
+	b.Close()
+	cl := rpc.NewClient(a)
+	defer cl.Close()
+
+	w1 := &mirrorConnection{workerAddr: "w1", maxJobs: 4, availableJobs: 1, rpcClient: cl}
+	c := &mirrorConnections{
+		mirrors: map[string]*mirrorConnection{"w1": w1},
+		retired: map[*mirrorConnection]bool{},
+	}
+
+	c.applyGrants(map[string]int{"w1": 2})
+	if c.mirrors["w1"] != w1 || w1.maxJobs != 2 || w1.availableJobs != -1 {
+		t.Errorf("got mirror %v, %d/%d jobs, want shrunk to 2", c.mirrors["w1"], w1.availableJobs, w1.maxJobs)
+	}
+
+	c.applyGrants(map[string]int{})
+	if c.mirrors["w1"] != nil || !w1.retired {
+		t.Errorf("mirror not retired")
+	}
+	req := c.allocationRequest()
+	if req.Held["w1"] != 2 || req.Busy["w1"] != 2 {
+		t.Errorf("retired mirror: held %v, busy %v", req.Held, req.Busy)
+	}
+}
@@ -29,6 +29,10 @@ type workerFSState struct {
 	// Task ids that have results pending in this FS.
 	taskIds []int
 
+	// Set if one of the tasks was cancelled. The results are then
+	// thrown away on reaping.
+	cancelled bool
+
 	// workerFS that this state belongs to.
 	fs *workerFS
 }
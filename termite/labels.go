@@ -0,0 +1,49 @@
+package termite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Labels are key=value pairs describing a worker, eg. its pool,
+// toolchain or machine size. Used as a selector, they list the labels
+// a worker must have.
+type Labels map[string]string
+
+// ParseLabels parses a comma separated list of key=value pairs.
+func ParseLabels(s string) (Labels, error) {
+	l := Labels{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		i := strings.Index(kv, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("label %q must be of the form key=value", kv)
+		}
+		l[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return l, nil
+}
+
+func (l Labels) String() string {
+	var kvs []string
+	for k, v := range l {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+// Matches returns whether l has all the labels of the selector. An
+// empty selector matches everything.
+func (l Labels) Matches(selector Labels) bool {
+	for k, v := range selector {
+		if got, ok := l[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package termite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLabels(t *testing.T) {
+	l, err := ParseLabels("pool=bigmem, arch=amd64,")
+	if err != nil {
+		t.Fatalf("ParseLabels: %v", err)
+	}
+	if got := l.String(); got != "arch=amd64,pool=bigmem" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := ParseLabels("pool"); err == nil {
+		t.Errorf("ParseLabels without value should fail")
+	}
+
+	if !l.Matches(nil) || !l.Matches(Labels{"pool": "bigmem"}) {
+		t.Errorf("%v should match", l)
+	}
+	if l.Matches(Labels{"pool": "small"}) || l.Matches(Labels{"os": "linux"}) {
+		t.Errorf("%v should not match", l)
+	}
+}
+
+func TestMirrorConnectionsPickLabels(t *testing.T) {
+	small := &mirrorConnection{workerAddr: "small", maxJobs: 2, availableJobs: 2}
+	big := &mirrorConnection{workerAddr: "big", maxJobs: 1, availableJobs: 1}
+	c := &mirrorConnections{
+		mirrors: map[string]*mirrorConnection{"small": small, "big": big},
+		labels: map[string]Labels{
+			"small": {"pool": "default"},
+			"big":   {"pool": "bigmem"},
+		},
+		wantedMaxJobs: 3,
+	}
+	sel := Labels{"pool": "bigmem"}
+	if got, err := c.pick(&taskAffinity{}, sel); got != big {
+		t.Fatalf("got %v, %v, want big", got, err)
+	}
+	if got := c.pickIdle(nil, sel); got != nil {
+		t.Errorf("pickIdle: got %v, want nil", got.workerAddr)
+	}
+	// Busy, so the task queues on the matching worker.
+	if got, err := c.pick(&taskAffinity{}, sel); got != big {
+		t.Errorf("got %v, %v, want big", got, err)
+	}
+	if _, err := c.pick(&taskAffinity{}, Labels{"pool": "gpu"}); err == nil {
+		t.Errorf("pick for unknown pool should fail")
+	}
+}
+
+func TestCoordinatorListSelector(t *testing.T) {
+	c := NewCoordinator(&CoordinatorOptions{})
+	c.workers["a:1"] = &WorkerRegistration{Registration: Registration{Address: "a:1"}}
+	c.workers["b:1"] = &WorkerRegistration{Registration: Registration{
+		Address: "b:1", Labels: Labels{"pool": "bigmem"}}}
+	c.lastChange = time.Now()
+
+	rep := ListResponse{}
+	if err := c.List(&ListRequest{Selector: Labels{"pool": "bigmem"}}, &rep); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(rep.Registrations) != 1 || rep.Registrations[0].Address != "b:1" {
+		t.Errorf("got %v, want only b:1", rep.Registrations)
+	}
+}
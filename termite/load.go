@@ -0,0 +1,90 @@
+package termite
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/hanwen/termite/stats"
+)
+
+// WorkerLoad summarizes how busy a worker is.
+type WorkerLoad struct {
+	// CPU used by the worker and its tasks recently, as a fraction
+	// of the machine's CPUs.
+	Cpu float64
+
+	// Memory available on the machine.
+	MemAvailable stats.MemCounter
+
+	// Tasks running, and the maximum.
+	Jobs    int
+	MaxJobs int
+
+	// When this was measured. Zero if the load is unknown.
+	Updated time.Time
+}
+
+// Number of recent CPU samples (one per second) to average.
+const loadCpuSamples = 10
+
+// Memory available beyond this doesn't make a worker more
+// attractive.
+const ampleMemory = 4 << 30
+
+func loadFromStatus(status *WorkerStatusResponse) WorkerLoad {
+	l := WorkerLoad{
+		MemAvailable: status.MemAvailable,
+		MaxJobs:      status.MaxJobCount,
+		Updated:      time.Now(),
+	}
+	for _, m := range status.MirrorStatus {
+		for _, fs := range m.Fses {
+			l.Jobs += len(fs.Tasks)
+		}
+	}
+
+	samples := status.CpuStats
+	if len(samples) > loadCpuSamples {
+		samples = samples[len(samples)-loadCpuSamples:]
+	}
+	if len(samples) > 0 && status.Cpus > 0 {
+		var total time.Duration
+		for _, s := range samples {
+			total += s.Total()
+		}
+		l.Cpu = float64(total) / float64(time.Duration(len(samples))*time.Second) / float64(status.Cpus)
+	}
+	return l
+}
+
+// score returns how attractive the worker is for new work, between 0
+// and 1.5. Workers of unknown load score in the middle.
+func (l WorkerLoad) score() float64 {
+	if l.Updated.IsZero() {
+		return 0.75
+	}
+	idle := 1 - l.Cpu
+	if idle < 0 {
+		idle = 0
+	}
+	mem := float64(l.MemAvailable) / ampleMemory
+	if mem > 1 {
+		mem = 1
+	}
+	return idle + 0.5*mem
+}
+
+// pickLeastLoaded returns the address with the best load score. A
+// little noise keeps masters from all choosing the same worker.
+func pickLeastLoaded(addrs []string, loads map[string]WorkerLoad) string {
+	best := ""
+	bestScore := -1.0
+	for _, a := range addrs {
+		s := loads[a].score() + 0.1*rand.Float64()
+		if s > bestScore {
+			best = a
+			bestScore = s
+		}
+	}
+	return best
+}
@@ -0,0 +1,40 @@
+package termite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hanwen/termite/stats"
+)
+
+func TestLoadFromStatus(t *testing.T) {
+	status := &WorkerStatusResponse{
+		MaxJobCount:  4,
+		Cpus:         2,
+		MemAvailable: 1 << 30,
+		MirrorStatus: []MirrorStatusResponse{{
+			Fses: []FuseFsStatus{{Tasks: []string{"a", "b"}}, {Tasks: []string{"c"}}},
+		}},
+	}
+	for i := 0; i < 20; i++ {
+		status.CpuStats = append(status.CpuStats, stats.CpuStat{ChildCpu: time.Second})
+	}
+	l := loadFromStatus(status)
+	if l.Cpu != 0.5 || l.Jobs != 3 || l.MaxJobs != 4 || l.MemAvailable != 1<<30 {
+		t.Errorf("got %+v", l)
+	}
+}
+
+func TestPickLeastLoaded(t *testing.T) {
+	now := time.Now()
+	loads := map[string]WorkerLoad{
+		"busy":    {Cpu: 0.95, MemAvailable: 8 << 30, Updated: now},
+		"swapped": {Cpu: 0.1, MemAvailable: 0, Updated: now},
+		"idle":    {Cpu: 0.1, MemAvailable: 8 << 30, Updated: now},
+	}
+	for i := 0; i < 10; i++ {
+		if got := pickLeastLoaded([]string{"busy", "swapped", "idle", "unknown"}, loads); got != "idle" {
+			t.Fatalf("got %q, want idle", got)
+		}
+	}
+}
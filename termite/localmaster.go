@@ -37,9 +37,27 @@ func (m *LocalMaster) Run(req *WorkRequest, rep *WorkResponse) error {
 	if req.StdinId != "" {
 		req.StdinConn = m.listener.Pending().accept(req.StdinId)
 	}
+	if req.StdoutId != "" {
+		req.StdoutConn = m.listener.Pending().accept(req.StdoutId)
+	}
+	if req.StderrId != "" {
+		req.StderrConn = m.listener.Pending().accept(req.StderrId)
+	}
 	return m.master.run(req, rep)
 }
 
+func (m *LocalMaster) Cancel(req *CancelRequest, rep *CancelResponse) error {
+	rep.Found = m.master.running.cancel(req.ClientId)
+	return nil
+}
+
+// AbortAll cancels all tasks, eg. when the build as a whole is
+// aborted.
+func (m *LocalMaster) AbortAll(req *AbortAllRequest, rep *AbortAllResponse) error {
+	rep.Cancelled = m.master.running.cancelAll()
+	return nil
+}
+
 func (m *LocalMaster) Shutdown(req *int, rep *int) error {
 	m.master.quit <- 1
 	return nil
@@ -47,7 +65,11 @@ func (m *LocalMaster) Shutdown(req *int, rep *int) error {
 
 func (m *LocalMaster) RefreshAttributeCache(input *int, output *int) error {
 	log.Println("Refreshing attribute cache")
-	m.master.refreshAttributeCache()
+	if m.master.watcher != nil {
+		m.master.watcher.flush()
+	} else {
+		m.master.refreshAttributeCache()
+	}
 	m.master.setAnalysisDir()
 	log.Println("Refresh done")
 	return nil
@@ -17,6 +17,17 @@ type LocalRule struct {
 	Recurse     bool
 	SkipRefresh bool
 	Debug       bool
+
+	// For remote commands, overrides the limits of the worker.
+	Limits ResourceLimits
+
+	// For remote commands, the labels a worker must have, eg.
+	// {"pool": "bigmem"}.
+	Labels Labels
+
+	// For remote commands, overrides the master's task timeout.
+	// In seconds.
+	Timeout float64
 }
 
 type localDecider struct {
@@ -33,3 +33,11 @@ func TestLocalDeciderDefault(t *testing.T) {
 		t.Error("termite-make should run locally. Rule:", r)
 	}
 }
+
+func TestLocalDeciderLimits(t *testing.T) {
+	buf := bytes.NewBufferString(`[{"Regexp": ".*ld", "Limits": {"Memory": 1024, "Pids": 10}, "Timeout": 1.5}]`)
+	r := newLocalDecider(buf).ShouldRunLocally("ld -o foo")
+	if r == nil || r.Limits.Memory != 1024 || r.Limits.Pids != 10 || r.Timeout != 1.5 {
+		t.Errorf("got rule %v", r)
+	}
+}
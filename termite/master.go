@@ -33,6 +33,27 @@ type Master struct {
 	quit          chan int
 	dialer        connDialer
 
+	// Which workers have which content.
+	locations *contentLocations
+
+	// Tasks in progress, for cancellation.
+	running *runningTasks
+
+	// Finished tasks, by outcome.
+	tasks *stats.Counters
+
+	// How long commands took before.
+	durations *durationHistory
+
+	// Nil if the action cache is disabled.
+	actions *actionCache
+
+	// Where to snapshot the attribute cache, or "" if disabled.
+	attributeSnapshot string
+
+	// Nil if we rescan the file system after local commands.
+	watcher *fsWatcher
+
 	analysisDirMu sync.Mutex
 	analysisDir   string
 }
@@ -47,6 +68,16 @@ type MasterOptions struct {
 	// How often a failed should be retried on another worker.
 	RetryCount int
 
+	// Timeout for tasks that don't set one; 0 means none.
+	TaskTimeout time.Duration
+
+	// If set, tasks that time out are retried on another worker.
+	RetryTimeouts bool
+
+	// If positive, tasks that take this many times longer than
+	// usual are duplicated on an idle worker.
+	SpeculationFactor float64
+
 	// List of files that should not be served
 	Excludes []string
 
@@ -56,6 +87,9 @@ type MasterOptions struct {
 	// Address of the coordinator.
 	Coordinator string
 
+	// Only use workers with these labels.
+	WorkerLabels Labels
+
 	Secret []byte
 
 	MaxJobs int
@@ -86,6 +120,21 @@ type MasterOptions struct {
 
 	// Dump action graph data into this directory
 	AnalysisDir string
+
+	// If set, remember the results of remote commands, and replay
+	// them if the command and the files it read are unchanged.
+	ActionCache bool
+
+	// Address of a shared cache server. Implies ActionCache.
+	CacheServer string
+
+	// If set, save the attribute cache on exit and periodically,
+	// and reload it on startup.
+	SnapshotAttributes bool
+
+	// If set, track changes to the source and writable roots with
+	// inotify, rather than rescanning after local commands.
+	WatchFiles bool
 }
 
 type replayRequest struct {
@@ -176,6 +225,9 @@ func NewMaster(options *MasterOptions) *Master {
 		replayChannel: make(chan *replayRequest, 1),
 		quit:          make(chan int, 0),
 		timing:        stats.NewTimerStats(),
+		running:       newRunningTasks(),
+		durations:     newDurationHistory(1 << 14),
+		tasks:         stats.NewCounters(),
 	}
 	m.contentStore = cba.NewStore(&options.StoreOptions, m.timing)
 
@@ -205,6 +257,9 @@ func NewMaster(options *MasterOptions) *Master {
 	m.mirrors = newMirrorConnections(
 		m, options.Coordinator, options.MaxJobs)
 	m.mirrors.keepAlive = options.KeepAlive
+	m.mirrors.selector = options.WorkerLabels
+	m.mirrors.masterId = fmt.Sprintf("%s:%d:%x", Hostname, os.Getpid(), RandomBytes(4))
+	m.mirrors.user = os.Getenv("USER")
 	m.attributes = attr.NewAttributeCache(func(n string) *attr.FileAttr {
 		return m.uncachedGetAttr(n)
 	},
@@ -213,8 +268,20 @@ func NewMaster(options *MasterOptions) *Master {
 			return fuse.ToAttr(fi)
 		})
 	m.fileServer = attr.NewServer(m.attributes, m.timing)
+	m.locations = newContentLocations(1 << 16)
+	if o.ActionCache || o.CacheServer != "" {
+		m.actions = newActionCache(m.contentStore,
+			filepath.Join(o.StoreOptions.Dir, "actions"))
+	}
+	if o.CacheServer != "" {
+		m.actions.remote = newRemoteActionCache(o.CacheServer, m.dialer, m.contentStore)
+	}
 	m.CheckPrivate()
 	m.setAnalysisDir()
+	if o.SnapshotAttributes {
+		m.attributeSnapshot = m.attributeSnapshotPath()
+		m.loadAttributes()
+	}
 
 	// Generate taskids.
 	go func() {
@@ -276,6 +343,9 @@ func (m *Master) FetchAll() {
 }
 
 func (m *Master) Start() {
+	if m.options.WatchFiles {
+		m.startWatcher()
+	}
 	if m.options.FetchAll {
 		go m.FetchAll()
 	}
@@ -348,7 +418,7 @@ func (m *Master) createMirror(addr string, jobs int) (*mirrorConnection, error)
 	}
 	closeMe = nil
 
-	go attr.ServeRPC(m.fileServer, revConn)
+	go m.serveReverse(revConn, addr)
 
 	go m.contentStore.ServeConn(revContentConn)
 
@@ -360,6 +430,7 @@ func (m *Master) createMirror(addr string, jobs int) (*mirrorConnection, error)
 		reverseContentConn: revContentConn,
 		maxJobs:            rep.GrantedJobCount,
 		availableJobs:      rep.GrantedJobCount,
+		recent:             newRecentTasks(),
 	}
 	mc.fileSetWaiter = attr.NewFileSetWaiter(func(fset attr.FileSet) error {
 		return mc.replay(fset)
@@ -368,13 +439,41 @@ func (m *Master) createMirror(addr string, jobs int) (*mirrorConnection, error)
 	return mc, nil
 }
 
-func (m *Master) runOnMirror(mirror *mirrorConnection, req *WorkRequest, rep *WorkResponse) error {
+// serveReverse serves the RPCs that the worker at addr makes to the
+// master.
+func (m *Master) serveReverse(conn io.ReadWriteCloser, addr string) {
+	rs := rpc.NewServer()
+	rs.Register(m.fileServer)
+	rs.RegisterName("Locator", &contentLocator{master: m, addr: addr})
+	rs.ServeConn(conn)
+}
+
+func (m *Master) runOnMirror(mirror *mirrorConnection, req *WorkRequest, rep *WorkResponse, out *attemptOutput) error {
+	// The phases of this attempt, and those of the worker, go in
+	// rep.Timings.
+	base := m.running.started(req.TaskId)
+	if base.IsZero() {
+		base = time.Now()
+	}
+	var timings []Timing
+	defer func() { rep.Timings = timings }()
+
+	start := time.Now()
 	m.mirrors.stats.Enter("send")
 	err := m.attributes.Send(mirror)
 	m.mirrors.stats.Exit("send")
+	timings = append(timings, newTiming("send", base, start))
 	if err != nil {
 		return err
 	}
+	rep.inputs = nil
+	if m.keepInputs() {
+		if v := m.attributes.Freeze(); m.running.addView(req.TaskId, v) {
+			rep.inputs = v
+		} else {
+			v.Release()
+		}
+	}
 
 	defer m.mirrors.jobDone(mirror)
 
@@ -397,30 +496,110 @@ func (m *Master) runOnMirror(mirror *mirrorConnection, req *WorkRequest, rep *Wo
 		req.StdinConn = nil
 	}
 
+	if err := out.open(m.dialer, mirror.workerAddr, req); err != nil {
+		out.wait(true)
+		return err
+	}
+
 	log.Printf("Running task %d on %s: %v", req.TaskId, mirror.workerAddr, req.Argv)
 	if req.Debug {
 		log.Println("with environment", req.Env)
 	}
 
+	if !m.running.running(req.TaskId, mirror) {
+		out.wait(true)
+		return errCancelled
+	}
 	mirror.fileSetWaiter.Prepare(req.TaskId)
+	rep.Timings = nil
+	start = time.Now()
 	m.mirrors.stats.Enter("remote")
 	err = mirror.rpcClient.Call("Mirror.Run", req, rep)
 	m.mirrors.stats.Exit("remote")
-	if err == nil {
+	timings = append(timings, newTiming("remote", base, start))
+	for _, t := range rep.Timings {
+		t.Start += start.Sub(base).Seconds()
+		timings = append(timings, t)
+	}
+	succeeded := err == nil && !rep.Discarded && !rep.TimedOut
+	cancelled := m.running.finished(req.TaskId, mirror, succeeded)
+	if err == nil && rep.OomKilled {
+		log.Printf("Task %d ran out of memory on %s: %v", req.TaskId, mirror.workerAddr, req.Argv)
+	}
+	out.wait(err != nil)
+	if isDrainingError(err) {
+		// The task did not run; it will be retried elsewhere.
+		mirror.fileSetWaiter.Discard(nil, req.TaskId)
+		return err
+	}
+	timedOut := err == nil && rep.TimedOut
+	if err == nil && rep.Discarded {
+		mirror.fileSetWaiter.Discard(rep.TaskIds, req.TaskId)
+		if !timedOut {
+			err = attr.ErrDiscarded
+		}
+	} else if err == nil && cancelled && rep.FileSet != nil {
+		// The task finished before the cancel arrived. Its
+		// files can't be told apart from those of the tasks
+		// sharing its file system, so those are discarded too,
+		// and retried.
+		log.Printf("Discarding results of cancelled task %d for tasks %v", req.TaskId, rep.TaskIds)
+		mirror.fileSetWaiter.Discard(rep.TaskIds, req.TaskId)
+	} else if err == nil && !cancelled && !timedOut {
+		start = time.Now()
 		m.mirrors.stats.Enter("filewait")
 		err = mirror.fileSetWaiter.Wait(rep.FileSet, rep.TaskIds, req.TaskId)
 		m.mirrors.stats.Exit("filewait")
+		timings = append(timings, newTiming("filewait", base, start))
+	}
+	if cancelled {
+		mirror.fileSetWaiter.Discard(nil, req.TaskId)
+		return errCancelled
+	}
+	if timedOut {
+		// The worker throws away the results.
+		mirror.fileSetWaiter.Discard(nil, req.TaskId)
+		log.Printf("Task %d timed out after %v on %s: %v", req.TaskId, req.Timeout, mirror.workerAddr, req.Argv)
+		if m.options.RetryTimeouts {
+			return errTimedOut
+		}
+	}
+	if err == nil && rep.FileSet != nil {
+		for _, f := range rep.FileSet.Files {
+			if f.Hash != "" {
+				m.locations.add(f.Hash, mirror.workerAddr)
+			}
+		}
 	}
 	return err
 }
 
-func (m *Master) runOnce(req *WorkRequest, rep *WorkResponse) error {
-	mirror, err := m.mirrors.pick()
-	if err != nil {
+// runOnce runs the request on a worker. Draining workers don't count
+// as a failed attempt.
+func (m *Master) runOnce(req *WorkRequest, rep *WorkResponse, out *taskOutput) error {
+	for {
+		mirror, err := m.mirrors.pick(m.affinity(req), req.Labels)
+		if err != nil {
+			return err
+		}
+		a := out.attempt()
+		err = m.runAttempt(mirror, req, rep, a)
+		out.use(a)
+		if !isDrainingError(err) {
+			return err
+		}
+	}
+}
+
+// runAttempt runs the request on the mirror, and drops the mirror if
+// it fails.
+func (m *Master) runAttempt(mirror *mirrorConnection, req *WorkRequest, rep *WorkResponse, out *attemptOutput) error {
+	err := m.runOnMirror(mirror, req, rep, out)
+	if isDrainingError(err) {
+		m.mirrors.retire(mirror)
 		return err
 	}
-	err = m.runOnMirror(mirror, req, rep)
-	if err != nil {
+	if err != nil && err != errCancelled && err != errTimedOut && err != attr.ErrDiscarded {
 		m.mirrors.drop(mirror, err)
 		return err
 	}
@@ -445,20 +624,61 @@ func (m *Master) setAnalysisDir() {
 	m.analysisDir = d
 }
 
+// keepInputs returns whether tasks should keep the attributes as
+// they were sent, for the action cache and the analysis dumps.
+// Keeping them makes every attribute change more expensive.
+func (m *Master) keepInputs() bool {
+	m.analysisDirMu.Lock()
+	defer m.analysisDirMu.Unlock()
+	return m.actions != nil || m.analysisDir != ""
+}
+
 func (m *Master) run(req *WorkRequest, rep *WorkResponse) (err error) {
 	m.mirrors.stats.Enter("run")
 	defer m.mirrors.stats.Exit("run")
 
 	m.analysisDirMu.Lock()
-	if m.analysisDir != "" {
+	analysisDir := m.analysisDir
+	m.analysisDirMu.Unlock()
+	if analysisDir != "" {
 		req.TrackReads = true
-		defer DumpAnnotations(req, rep, time.Now(), m.analysisDir, m.options.WritableRoot)
 	}
-	m.analysisDirMu.Unlock()
+	if m.actions != nil {
+		req.TrackReads = true
+	}
+
+	out := newTaskOutput(req)
+	defer out.Close()
 
+	if req.Timeout == 0 {
+		req.Timeout = m.options.TaskTimeout
+	}
 	req.TaskId = <-m.taskIds
+	m.running.add(req)
+	defer m.running.remove(req.TaskId)
+	if analysisDir != "" {
+		start := m.running.started(req.TaskId)
+		defer func() {
+			DumpAnnotations(req, rep, start, analysisDir, m.options.WritableRoot, m.readInputs(rep))
+		}()
+	}
+
+	outcome := ""
+	defer func() {
+		if outcome == "" {
+			outcome = taskOutcome(rep, err)
+		}
+		m.tasks.Add(outcome, 1)
+	}()
+
 	if m.MaybeRunInMaster(req, rep) {
 		log.Println("Ran in master:", req.Summary())
+		outcome = "master"
+		return nil
+	}
+
+	if m.lookupAction(req, rep) {
+		outcome = "cached"
 		return nil
 	}
 
@@ -467,13 +687,24 @@ func (m *Master) run(req *WorkRequest, rep *WorkResponse) (err error) {
 		if err != nil {
 			return err
 		}
-		return m.runOnMirror(mc, req, rep)
+		a := out.attempt()
+		err = m.runOnMirror(mc, req, rep, a)
+		out.use(a)
+		return err
 	}
 
-	err = m.runOnce(req, rep)
-	for i := 0; i < m.options.RetryCount && err != nil; i++ {
+	err = m.runSpeculatively(req, rep, out)
+	if isDrainingError(err) {
+		err = m.runOnce(req, rep, out)
+	}
+	for i := 0; i < m.options.RetryCount && err != nil && err != errCancelled; i++ {
 		log.Println("Retrying; last error:", err)
-		err = m.runOnce(req, rep)
+		err = m.runOnce(req, rep, out)
+	}
+	if err == nil {
+		if full, ok := out.response(rep); ok {
+			m.storeAction(req, full)
+		}
 	}
 
 	return err
@@ -622,6 +853,20 @@ func (m *Master) replay(fset attr.FileSet) {
 	<-req.Done
 }
 
+func (m *Master) startWatcher() {
+	roots := []string{m.options.WritableRoot}
+	if m.options.SourceRoot != "" && m.options.SourceRoot != m.options.WritableRoot {
+		roots = append(roots, m.options.SourceRoot)
+	}
+	w, err := newFsWatcher(m, roots)
+	if err != nil {
+		log.Printf("Not watching files, falling back to full refreshes: %v", err)
+		return
+	}
+	m.watcher = w
+	go w.run()
+}
+
 func (m *Master) refreshAttributeCache() {
 	updated := m.attributes.Refresh("")
 	m.attributes.Queue(updated)
@@ -636,6 +881,7 @@ func (m *Master) fetchAll(path string) {
 
 func (m *Master) waitForExit() {
 	go m.mirrors.refreshWorkers()
+	go m.mirrors.periodicAllocate()
 	ticker := time.NewTicker(m.options.Period)
 
 L:
@@ -647,6 +893,12 @@ L:
 		case <-ticker.C:
 			log.Println("periodic household.")
 			m.mirrors.periodicHouseholding()
+			if m.attributeSnapshot != "" {
+				m.saveAttributes()
+			}
 		}
 	}
+	if m.attributeSnapshot != "" {
+		m.saveAttributes()
+	}
 }
@@ -63,7 +63,7 @@ func (m *Master) statusHandler(w http.ResponseWriter, req *http.Request) {
 	m.writeThroughput(w)
 
 	fmt.Fprintf(w, "<p>Master parallelism (--jobs): %d. Reserved job slots: %d",
-		m.mirrors.wantedMaxJobs, m.mirrors.maxJobs())
+		m.mirrors.wantedMaxJobs, m.mirrors.maxJobs(nil))
 	fmt.Fprintf(w, "</body></html>")
 }
 
@@ -90,6 +90,18 @@ func (m *Master) ServeHTTP(port int) {
 		func(w http.ResponseWriter, req *http.Request) {
 			m.statusHandler(w, req)
 		})
+	http.HandleFunc("/metrics",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveMetrics(w, m.writeMetrics)
+		})
+	http.HandleFunc("/api/status",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveJSON(w, m.apiStatus())
+		})
+	http.HandleFunc("/api/tasks",
+		func(w http.ResponseWriter, req *http.Request) {
+			serveJSON(w, m.running.status())
+		})
 	addr := fmt.Sprintf(":%d", port)
 	log.Println("HTTP status on", addr)
 	err := http.ListenAndServe(addr, nil)
@@ -0,0 +1,148 @@
+package termite
+
+import (
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/hanwen/termite/stats"
+)
+
+// taskOutcome classifies the result of a task, for counting.
+func taskOutcome(rep *WorkResponse, err error) string {
+	switch {
+	case err == errCancelled:
+		return "cancelled"
+	case isDrainingError(err):
+		return "refused"
+	case err != nil:
+		return "error"
+	case rep.Discarded:
+		return "discarded"
+	case rep.TimedOut:
+		return "timed_out"
+	case rep.OomKilled:
+		return "oom_killed"
+	case rep.Exit.ExitStatus() != 0:
+		return "failed"
+	}
+	return "ok"
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func serveMetrics(w http.ResponseWriter, write func(io.Writer)) {
+	w.Header().Set("Content-Type", stats.MetricsContentType)
+	write(w)
+}
+
+func (m *Master) writeMetrics(w io.Writer) {
+	mw := stats.NewMetricsWriter(w)
+	mw.Timings("termite_master_rpc", m.timing)
+	m.mirrors.stats.WriteMetrics(mw, "termite_master")
+	m.contentStore.WriteMetrics(mw, "termite_master_content")
+	m.tasks.WriteMetrics(mw, "termite_master_tasks_total", "Finished tasks by outcome.", "outcome")
+
+	c := m.mirrors
+	c.Mutex.Lock()
+	wanted, reserved, available := c.wantedMaxJobs, c.maxJobs(nil), c.availableJobs(nil)
+	mirrors, workers := len(c.mirrors), len(c.workers)
+	c.Mutex.Unlock()
+	mw.Gauge("termite_master_job_slots_wanted", "Job slots we want (-jobs).", float64(wanted))
+	mw.Gauge("termite_master_job_slots_reserved", "Job slots reserved on workers.", float64(reserved))
+	mw.Gauge("termite_master_job_slots_available", "Reserved job slots not in use.", float64(available))
+	mw.Gauge("termite_master_mirrors", "Workers we have a mirror on.", float64(mirrors))
+	mw.Gauge("termite_master_workers", "Workers known from the coordinator.", float64(workers))
+	mw.Gauge("termite_master_attribute_cache_files", "Entries in the attribute cache.", float64(m.attributes.Len()))
+}
+
+// jobCounts returns the job slots reserved by masters, and the tasks
+// running and waiting for a slot.
+func (wm *WorkerMirrors) jobCounts() (reserved, running, waiting int) {
+	for _, m := range wm.mirrors() {
+		m.fsMutex.Lock()
+		reserved += m.maxJobCount
+		running += m.runningCount()
+		waiting += m.waiting
+		m.fsMutex.Unlock()
+	}
+	return reserved, running, waiting
+}
+
+func (w *Worker) writeMetrics(out io.Writer) {
+	mw := stats.NewMetricsWriter(out)
+	mw.Timings("termite_worker_rpc", w.contentTimings)
+	w.stats.WriteMetrics(mw, "termite_worker")
+	w.content.WriteMetrics(mw, "termite_worker_content")
+	w.tasks.WriteMetrics(mw, "termite_worker_tasks_total", "Finished tasks by outcome.", "outcome")
+
+	reserved, running, waiting := w.mirrors.jobCounts()
+	mw.Gauge("termite_worker_job_slots", "Job slots (-jobs).", float64(w.options.Jobs))
+	mw.Gauge("termite_worker_job_slots_reserved", "Job slots reserved by masters.", float64(reserved))
+	mw.Gauge("termite_worker_tasks_running", "Tasks running.", float64(running))
+	mw.Gauge("termite_worker_tasks_waiting", "Tasks waiting for a job slot.", float64(waiting))
+	mw.Gauge("termite_worker_mirrors", "Masters connected.", float64(len(w.mirrors.mirrors())))
+	mw.Gauge("termite_worker_accepting", "Whether the worker accepts work.", boolGauge(w.accepting))
+	mw.Gauge("termite_worker_draining", "Whether the worker is draining.", boolGauge(w.isDraining()))
+	mw.Gauge("termite_worker_memory_available_bytes", "Memory available on the machine.",
+		float64(stats.GetMemAvailable()))
+}
+
+func (c *Coordinator) writeMetrics(out io.Writer) {
+	mw := stats.NewMetricsWriter(out)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	addrs := []string{}
+	draining, slots := 0, 0
+	for a, w := range c.workers {
+		addrs = append(addrs, a)
+		if w.Draining {
+			draining++
+		} else {
+			slots += w.jobs()
+		}
+	}
+	sort.Strings(addrs)
+	mw.Gauge("termite_coordinator_workers", "Registered workers.", float64(len(addrs)))
+	mw.Gauge("termite_coordinator_workers_draining", "Registered workers that are draining.", float64(draining))
+	mw.Gauge("termite_coordinator_job_slots", "Job slots on workers that are not draining.", float64(slots))
+
+	for _, a := range addrs {
+		if l := c.workers[a].Load; !l.Updated.IsZero() {
+			mw.Gauge("termite_coordinator_worker_cpu", "CPU use of the worker, between 0 and 1.", l.Cpu, "worker", a)
+		}
+	}
+	for _, a := range addrs {
+		if l := c.workers[a].Load; !l.Updated.IsZero() {
+			mw.Gauge("termite_coordinator_worker_jobs", "Tasks running on the worker.", float64(l.Jobs), "worker", a)
+		}
+	}
+
+	ids := []string{}
+	for id := range c.masters {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	mw.Gauge("termite_coordinator_masters", "Masters sharing the workers.", float64(len(ids)))
+	for _, id := range ids {
+		m := c.masters[id]
+		mw.Gauge("termite_coordinator_master_share", "Fair share of job slots.", float64(m.share),
+			"master", id, "user", m.User)
+	}
+	for _, id := range ids {
+		m := c.masters[id]
+		granted := 0
+		for _, n := range m.grants {
+			granted += n
+		}
+		mw.Gauge("termite_coordinator_master_granted", "Job slots granted.", float64(granted),
+			"master", id, "user", m.User)
+	}
+}
@@ -0,0 +1,45 @@
+package termite
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestTaskOutcome(t *testing.T) {
+	for _, c := range []struct {
+		rep  WorkResponse
+		err  error
+		want string
+	}{
+		{WorkResponse{}, nil, "ok"},
+		{WorkResponse{Exit: syscall.WaitStatus(1 << 8)}, nil, "failed"},
+		{WorkResponse{TimedOut: true}, nil, "timed_out"},
+		{WorkResponse{}, errCancelled, "cancelled"},
+		{WorkResponse{}, ErrDraining, "refused"},
+		{WorkResponse{}, ShuttingDownError, "error"},
+	} {
+		if got := taskOutcome(&c.rep, c.err); got != c.want {
+			t.Errorf("taskOutcome(%+v, %v): got %q, want %q", c.rep, c.err, got, c.want)
+		}
+	}
+}
+
+func TestCoordinatorMetrics(t *testing.T) {
+	c := NewCoordinator(&CoordinatorOptions{})
+	c.workers["w1"] = &WorkerRegistration{Registration: Registration{Address: "w1", Jobs: 4}}
+	c.workers["w2"] = &WorkerRegistration{Registration: Registration{Address: "w2", Jobs: 2, Draining: true}}
+
+	buf := &bytes.Buffer{}
+	c.writeMetrics(buf)
+	for _, want := range []string{
+		"termite_coordinator_workers 2\n",
+		"termite_coordinator_workers_draining 1\n",
+		"termite_coordinator_job_slots 4\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("missing %q in\n%s", want, buf.String())
+		}
+	}
+}
@@ -6,9 +6,13 @@ import (
 	"log"
 	"net"
 	"net/rpc"
+	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/hanwen/termite/attr"
+	"github.com/hanwen/termite/cba"
 )
 
 // State associated with one master.
@@ -33,11 +37,20 @@ type Mirror struct {
 	activeFses map[*workerFSState]bool
 	accepting  bool
 	killed     bool
+
+	// Tasks cancelled before they got a file system.
+	cancelled map[int]bool
+
+	// Recently finished tasks, so late cancellations for them
+	// are not kept in cancelled.
+	finished *cba.LruCache
 }
 
 func NewMirror(worker *Worker, rpcConn, revConn, contentConn, revContentConn io.ReadWriteCloser) (*Mirror, error) {
 	mirror := &Mirror{
 		activeFses:  map[*workerFSState]bool{},
+		cancelled:   map[int]bool{},
+		finished:    cba.NewLruCache(1024),
 		rpcConn:     rpcConn,
 		contentConn: contentConn,
 		worker:      worker,
@@ -46,9 +59,12 @@ func NewMirror(worker *Worker, rpcConn, revConn, contentConn, revContentConn io.
 	_, portString, _ := net.SplitHostPort(worker.listener.Addr().String())
 	id := Hostname + ":" + portString
 	mirror.cond = sync.NewCond(&mirror.fsMutex)
-	attrClient := attr.NewClient(revConn, id)
+	revClient := rpc.NewClient(revConn)
+	attrClient := attr.NewRPCClient(revClient, id)
 	mirror.rpcFs = NewRpcFs(attrClient, worker.content, revContentConn)
 	mirror.rpcFs.id = id
+	mirror.rpcFs.locator = revClient
+	mirror.rpcFs.peers = worker.peers
 	mirror.rpcFs.attr.Paranoia = worker.options.Paranoia
 
 	go mirror.serveRpc()
@@ -131,7 +147,7 @@ func (m *Mirror) newFs(t *WorkerTask) (fs *workerFSState, err error) {
 	defer m.fsMutex.Unlock()
 
 	m.waiting++
-	for m.runningCount() >= m.maxJobCount {
+	for m.runningCount() >= m.maxJobCount && !m.cancelled[t.req.TaskId] && !m.worker.isDraining() {
 		m.cond.Wait()
 	}
 	m.waiting--
@@ -139,9 +155,16 @@ func (m *Mirror) newFs(t *WorkerTask) (fs *workerFSState, err error) {
 	if !m.accepting {
 		return nil, ShuttingDownError
 	}
+	if m.cancelled[t.req.TaskId] {
+		delete(m.cancelled, t.req.TaskId)
+		return nil, errCancelled
+	}
+	if m.worker.isDraining() {
+		return nil, ErrDraining
+	}
 
 	for fs := range m.activeFses {
-		if !fs.reaping && len(fs.taskIds) < m.worker.options.ReapCount {
+		if !fs.reaping && !fs.cancelled && len(fs.taskIds) < m.worker.options.ReapCount {
 			fs.addTask(t)
 			return fs, nil
 		}
@@ -161,6 +184,7 @@ func (m *Mirror) newFs(t *WorkerTask) (fs *workerFSState, err error) {
 // Must hold lock.
 func (m *Mirror) prepareFS(fs *workerFSState) {
 	fs.reaping = false
+	fs.cancelled = false
 	fs.taskIds = make([]int, 0, m.worker.options.ReapCount)
 }
 
@@ -184,6 +208,20 @@ func (m *Mirror) reapFuse(state *workerFSState) (results *attr.FileSet, taskIds
 	return results, ids, reads
 }
 
+// discardFuse throws away the results in the file system, and
+// returns the tasks they belonged to.
+func (m *Mirror) discardFuse(state *workerFSState) (taskIds []int) {
+	log.Printf("Discarding fuse FS %v", state.fs.id)
+
+	ids := state.taskIds[:]
+	yield := state.fs.reap()
+	if err := os.RemoveAll(yield.dir); err != nil {
+		log.Printf("RemoveAll: %v", err)
+	}
+	m.returnFS(state)
+	return ids
+}
+
 func (m *Mirror) returnFS(state *workerFSState) {
 	m.fsMutex.Lock()
 	defer m.fsMutex.Unlock()
@@ -199,6 +237,45 @@ func (m *Mirror) returnFS(state *workerFSState) {
 	}
 }
 
+// Cancel kills a task. Since we can't tell which files in the file
+// system belong to which task, the results of all tasks sharing the
+// file system are thrown away, also if the task already finished but
+// its results were not sent yet.
+func (m *Mirror) Cancel(req *CancelRequest, rep *CancelResponse) error {
+	m.fsMutex.Lock()
+	defer m.fsMutex.Unlock()
+	for fs := range m.activeFses {
+		for _, id := range fs.taskIds {
+			if id == req.TaskId {
+				// If reaping, the results are on
+				// their way, and it's too late.
+				fs.cancelled = fs.cancelled || !fs.reaping
+				rep.Found = true
+			}
+		}
+		for t := range fs.tasks {
+			if t.req.TaskId == req.TaskId {
+				log.Printf("Cancelling task %d: %v", req.TaskId, t)
+				t.cancelled = true
+				t.Kill()
+			}
+		}
+	}
+	if !rep.Found && !m.finished.Has(strconv.Itoa(req.TaskId)) {
+		m.cancelled[req.TaskId] = true
+		// Wake up the task if it waits for a slot.
+		m.cond.Broadcast()
+	}
+	return nil
+}
+
+// Shrink gives up job slots. Requests to grow are ignored, as the
+// slots may be reserved by other masters.
+func (m *Mirror) Shrink(req *ShrinkRequest, rep *ShrinkResponse) error {
+	m.worker.mirrors.shrink(m, req.MaxJobCount)
+	return nil
+}
+
 func (m *Mirror) Update(req *UpdateRequest, rep *UpdateResponse) error {
 	m.updateFiles(req.Files)
 	return nil
@@ -215,7 +292,8 @@ func (m *Mirror) updateFiles(attrs []*attr.FileAttr) {
 	}
 }
 
-func (m *Mirror) Run(req *WorkRequest, rep *WorkResponse) error {
+func (m *Mirror) Run(req *WorkRequest, rep *WorkResponse) (err error) {
+	defer func() { m.worker.tasks.Add(taskOutcome(rep, err), 1) }()
 	m.worker.stats.Enter("run")
 
 	// Don't run m.updateFiles() as we don't want to issue
@@ -224,6 +302,7 @@ func (m *Mirror) Run(req *WorkRequest, rep *WorkResponse) error {
 	if err != nil {
 		return err
 	}
+	defer m.taskDone(req.TaskId)
 
 	err = task.Run()
 	if err != nil {
@@ -241,6 +320,14 @@ func (m *Mirror) Run(req *WorkRequest, rep *WorkResponse) error {
 	return nil
 }
 
+// taskDone forgets about cancellations for a task that returned.
+func (m *Mirror) taskDone(taskId int) {
+	m.fsMutex.Lock()
+	defer m.fsMutex.Unlock()
+	delete(m.cancelled, taskId)
+	m.finished.Add(strconv.Itoa(taskId), true)
+}
+
 const _DELETIONS = "DELETIONS"
 
 func (m *Mirror) newWorkerTask(req *WorkRequest, rep *WorkResponse) (*WorkerTask, error) {
@@ -248,12 +335,22 @@ func (m *Mirror) newWorkerTask(req *WorkRequest, rep *WorkResponse) (*WorkerTask
 	if req.StdinId != "" {
 		stdin = m.worker.listener.Pending().accept(req.StdinId)
 	}
+	var stdout, stderr io.ReadWriteCloser
+	if req.StdoutId != "" {
+		stdout = m.worker.listener.Pending().accept(req.StdoutId)
+	}
+	if req.StderrId != "" {
+		stderr = m.worker.listener.Pending().accept(req.StderrId)
+	}
 	task := &WorkerTask{
-		req:       req,
-		rep:       rep,
-		stdinConn: stdin,
-		mirror:    m,
-		taskInfo:  fmt.Sprintf("%v, dir %v", req.Argv, req.Dir),
+		req:        req,
+		rep:        rep,
+		stdinConn:  stdin,
+		stdoutConn: stdout,
+		stderrConn: stderr,
+		mirror:     m,
+		taskInfo:   fmt.Sprintf("%v, dir %v", req.Argv, req.Dir),
+		start:      time.Now(),
 	}
 	return task, nil
 }
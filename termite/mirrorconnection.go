@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net/rpc"
 	"strings"
 	"sync"
@@ -28,6 +27,12 @@ type mirrorConnection struct {
 	// Protected by mirrorConnections.Mutex.
 	maxJobs       int
 	availableJobs int
+	recent        *recentTasks
+
+	// Set if the worker is draining, or the coordinator reclaimed
+	// the slots. The connection is closed once the running tasks
+	// finish.
+	retired bool
 
 	master        *Master
 	fileSetWaiter *attr.FileSetWaiter
@@ -37,6 +42,13 @@ func (c *mirrorConnection) Id() string {
 	return c.workerAddr
 }
 
+func (c *mirrorConnection) close() {
+	c.rpcClient.Close()
+	c.contentClient.Close()
+	c.reverseConnection.Close()
+	c.reverseContentConn.Close()
+}
+
 func (c *mirrorConnection) replay(fset attr.FileSet) error {
 	// Must get data before we modify the file-system, so we don't
 	// leave the FS in a half-finished state.
@@ -79,24 +91,40 @@ type mirrorConnections struct {
 
 	wantedMaxJobs int
 
+	// Only use workers with these labels.
+	selector Labels
+
+	// Identify us to the coordinator, for fair sharing.
+	masterId string
+	user     string
+
 	stats *stats.ServerStats
 
 	// Protects all of the below.
 	sync.Mutex
 	workers        map[string]bool
+	loads          map[string]WorkerLoad
+	labels         map[string]Labels
 	mirrors        map[string]*mirrorConnection
 	lastActionTime time.Time
+
+	// Job slots the coordinator allows us on each worker, or nil
+	// if the coordinator doesn't broker slots.
+	grants map[string]int
+
+	// Retired mirrors that still run tasks. Their slots are still
+	// taken on the worker.
+	retired map[*mirrorConnection]bool
 }
 
-func (c *mirrorConnections) fetchWorkers(last *time.Time) (newMap map[string]bool, err error) {
-	newMap = map[string]bool{}
+func (c *mirrorConnections) fetchWorkers(last *time.Time) ([]Registration, error) {
 	client, err := rpc.DialHTTP("tcp", c.coordinator)
 	if err != nil {
 		log.Println("fetchWorkers: dialing coordinator:", err)
 		return nil, err
 	}
 	defer client.Close()
-	req := ListRequest{Latest: *last}
+	req := ListRequest{Latest: *last, Selector: c.selector}
 	rep := ListResponse{}
 	err = client.Call("Coordinator.List", &req, &rep)
 	if err != nil {
@@ -104,28 +132,32 @@ func (c *mirrorConnections) fetchWorkers(last *time.Time) (newMap map[string]boo
 		return nil, err
 	}
 
-	for _, v := range rep.Registrations {
-		newMap[v.Address] = true
-	}
-	if len(newMap) == 0 {
+	if len(rep.Registrations) == 0 {
 		log.Println("coordinator has no workers for us.")
 	}
 	*last = rep.LastChange
 
-	return newMap, nil
+	return rep.Registrations, nil
 }
 
 func (c *mirrorConnections) refreshWorkers() {
 	last := time.Unix(0, 0)
 	for {
-		newWorkers, err := c.fetchWorkers(&last)
+		regs, err := c.fetchWorkers(&last)
 		if err != nil {
 			time.Sleep(10 * time.Second)
 			continue
 		}
-		//log.Printf("Got %d workers %v", len(newWorkers), last)
+		//log.Printf("Got %d workers %v", len(regs), last)
 		c.Mutex.Lock()
-		c.workers = newWorkers
+		c.workers = map[string]bool{}
+		c.loads = map[string]WorkerLoad{}
+		c.labels = map[string]Labels{}
+		for _, r := range regs {
+			c.workers[r.Address] = true
+			c.loads[r.Address] = r.Load
+			c.labels[r.Address] = r.Labels
+		}
 		c.Mutex.Unlock()
 	}
 }
@@ -136,6 +168,7 @@ func newMirrorConnections(m *Master, coordinator string, maxJobs int) *mirrorCon
 		wantedMaxJobs: maxJobs,
 		workers:       make(map[string]bool),
 		mirrors:       make(map[string]*mirrorConnection),
+		retired:       map[*mirrorConnection]bool{},
 		coordinator:   coordinator,
 		keepAlive:     time.Minute,
 	}
@@ -152,11 +185,17 @@ func (c *mirrorConnections) periodicHouseholding() {
 	c.maybeDropConnections()
 }
 
+// matches returns whether the worker has the labels of the
+// selector. Must be called with lock held.
+func (c *mirrorConnections) matches(addr string, selector Labels) bool {
+	return c.labels[addr].Matches(selector)
+}
+
 // Must be called with lock held.
-func (c *mirrorConnections) availableJobs() int {
+func (c *mirrorConnections) availableJobs(selector Labels) int {
 	a := 0
 	for _, mc := range c.mirrors {
-		if mc.availableJobs > 0 {
+		if mc.availableJobs > 0 && c.matches(mc.workerAddr, selector) {
 			a += mc.availableJobs
 		}
 	}
@@ -164,10 +203,12 @@ func (c *mirrorConnections) availableJobs() int {
 }
 
 // Must be called with lock held.
-func (c *mirrorConnections) maxJobs() int {
+func (c *mirrorConnections) maxJobs(selector Labels) int {
 	a := 0
 	for _, mc := range c.mirrors {
-		a += mc.maxJobs
+		if c.matches(mc.workerAddr, selector) {
+			a += mc.maxJobs
+		}
 	}
 	return a
 }
@@ -182,7 +223,7 @@ func (c *mirrorConnections) maybeDropConnections() {
 	}
 
 	// Something is running.
-	if c.availableJobs() < c.maxJobs() {
+	if c.availableJobs(nil) < c.maxJobs(nil) {
 		return
 	}
 
@@ -196,10 +237,7 @@ func (c *mirrorConnections) maybeDropConnections() {
 
 func (c *mirrorConnections) dropConnections() {
 	for _, mc := range c.mirrors {
-		mc.rpcClient.Close()
-		mc.contentClient.Close()
-		mc.reverseConnection.Close()
-		mc.reverseContentConn.Close()
+		mc.close()
 		c.master.attributes.RmClient(mc)
 	}
 	c.mirrors = make(map[string]*mirrorConnection)
@@ -225,14 +263,24 @@ func (c *mirrorConnections) find(name string) (*mirrorConnection, error) {
 	return found, nil
 }
 
-func (c *mirrorConnections) pick() (*mirrorConnection, error) {
+// pick reserves a job slot for a task on a worker with the labels of
+// the selector. Of the mirrors with free slots, it prefers the one
+// most likely to have the task's inputs cached. It will block if none
+// available.
+func (c *mirrorConnections) pick(a *taskAffinity, selector Labels) (*mirrorConnection, error) {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 
-	if c.availableJobs() <= 0 {
-		c.tryConnect()
+	if c.availableJobs(selector) <= 0 {
+		c.tryConnect(selector)
+		if c.maxJobs(selector) == 0 && c.grants != nil {
+			c.waitForGrants(selector)
+		}
 
-		if c.maxJobs() == 0 {
+		if c.maxJobs(selector) == 0 {
+			if len(selector) > 0 {
+				return nil, fmt.Errorf("No workers found with labels %v.", selector)
+			}
 			// Didn't connect to anything.  Should
 			// probably direct the wrapper to compile
 			// locally.
@@ -240,12 +288,33 @@ func (c *mirrorConnections) pick() (*mirrorConnection, error) {
 		}
 	}
 
+	var best *mirrorConnection
+	bestScore := -1
+	bestIdle := 0.0
+	for _, v := range c.mirrors {
+		if v.availableJobs <= 0 || !c.matches(v.workerAddr, selector) {
+			continue
+		}
+		score := a.score(v)
+		// On ties, prefer idle machines, and spread our jobs.
+		idle := c.loads[v.workerAddr].score() + float64(v.availableJobs)/float64(v.maxJobs)
+		if score > bestScore || (score == bestScore && idle > bestIdle) {
+			best = v
+			bestScore = score
+			bestIdle = idle
+		}
+	}
+	if best != nil {
+		best.availableJobs--
+		best.recent.add(a.keys)
+		return best, nil
+	}
+
 	maxAvail := -1e9
 	var maxAvailMirror *mirrorConnection
 	for _, v := range c.mirrors {
-		if v.availableJobs > 0 {
-			v.availableJobs--
-			return v, nil
+		if !c.matches(v.workerAddr, selector) {
+			continue
 		}
 		l := float64(v.availableJobs) / float64(v.maxJobs)
 		if l > maxAvail {
@@ -255,36 +324,93 @@ func (c *mirrorConnections) pick() (*mirrorConnection, error) {
 	}
 
 	maxAvailMirror.availableJobs--
+	maxAvailMirror.recent.add(a.keys)
 	return maxAvailMirror, nil
 }
 
+// pickIdle returns a mirror other than exclude that has a free job
+// slot and the labels of the selector, or nil if there is none.
+func (c *mirrorConnections) pickIdle(exclude *mirrorConnection, selector Labels) *mirrorConnection {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	for _, v := range c.mirrors {
+		if v != exclude && v.availableJobs > 0 && c.matches(v.workerAddr, selector) {
+			v.availableJobs--
+			return v
+		}
+	}
+	return nil
+}
+
 func (c *mirrorConnections) drop(mc *mirrorConnection, err error) {
 	c.master.attributes.RmClient(mc)
 
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 	log.Printf("Dropping mirror %s. Reason: %s", mc.workerAddr, err)
-	mc.rpcClient.Close()
-	mc.contentClient.Close()
-	mc.reverseConnection.Close()
-	mc.reverseContentConn.Close()
-	delete(c.mirrors, mc.workerAddr)
+	mc.close()
+	if c.mirrors[mc.workerAddr] == mc {
+		delete(c.mirrors, mc.workerAddr)
+	}
+	delete(c.retired, mc)
 	delete(c.workers, mc.workerAddr)
 }
 
+// retire stops sending tasks to mc, as its worker is draining. Tasks
+// still running there may finish.
+func (c *mirrorConnections) retire(mc *mirrorConnection) {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if !mc.retired {
+		c.retireLocked(mc, "worker is draining")
+		delete(c.workers, mc.workerAddr)
+	}
+}
+
+// retireLocked stops sending tasks to mc, and closes it once its
+// tasks finish. Must hold lock.
+func (c *mirrorConnections) retireLocked(mc *mirrorConnection, reason string) {
+	log.Printf("Retiring mirror %s: %s", mc.workerAddr, reason)
+	mc.retired = true
+	if c.mirrors[mc.workerAddr] == mc {
+		delete(c.mirrors, mc.workerAddr)
+	}
+	c.retired[mc] = true
+	c.maybeCloseRetired(mc)
+}
+
+// Must hold lock.
+func (c *mirrorConnections) maybeCloseRetired(mc *mirrorConnection) {
+	if mc.retired && mc.availableJobs >= mc.maxJobs {
+		log.Printf("Closing retired mirror %s", mc.workerAddr)
+		delete(c.retired, mc)
+		mc.close()
+		c.master.attributes.RmClient(mc)
+	}
+}
+
+// connected returns whether we have a mirror on the given worker.
+func (c *mirrorConnections) connected(addr string) bool {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	_, ok := c.mirrors[addr]
+	return ok
+}
+
 func (c *mirrorConnections) jobDone(mc *mirrorConnection) {
 	c.Mutex.Lock()
 	defer c.Mutex.Unlock()
 
 	c.lastActionTime = time.Now()
 	mc.availableJobs++
+	c.maybeCloseRetired(mc)
 }
 
-func (c *mirrorConnections) idleWorkerAddress() string {
+func (c *mirrorConnections) idleWorkerAddress(selector Labels) string {
 	cands := []string{}
 	for addr := range c.workers {
 		_, ok := c.mirrors[addr]
-		if ok {
+		if ok || !c.matches(addr, selector) {
 			continue
 		}
 		cands = append(cands, addr)
@@ -293,40 +419,73 @@ func (c *mirrorConnections) idleWorkerAddress() string {
 	if len(cands) == 0 {
 		return ""
 	}
-	return cands[rand.Intn(len(cands))]
+	return pickLeastLoaded(cands, c.loads)
 }
 
-// Tries to connect to one extra worker.  Must already hold mutex.
-func (c *mirrorConnections) tryConnect() {
+// Tries to connect to extra workers with the labels of the selector.
+// Must already hold mutex.
+func (c *mirrorConnections) tryConnect(selector Labels) {
+	if c.grants != nil {
+		c.connectGranted(selector)
+		return
+	}
+
 	// We want to max out capacity of each worker, as that helps
 	// with cache hit rates on the worker.
-	wanted := c.wantedMaxJobs - c.maxJobs()
+	wanted := c.wantedMaxJobs - c.maxJobs(nil)
+	single := false
 	if wanted <= 0 {
-		return
+		// Tasks needing particular workers get one more, even
+		// if we have enough capacity elsewhere. Later tasks
+		// add another if it is busy.
+		if len(selector) == 0 || c.availableJobs(selector) > 0 {
+			return
+		}
+		single = true
 	}
 
 	for {
-		addr := c.idleWorkerAddress()
+		addr := c.idleWorkerAddress(selector)
 		if addr == "" {
 			break
 		}
-		c.Mutex.Unlock()
-		log.Printf("Creating mirror on %v, requesting %d jobs", addr, wanted)
-		mc, err := c.master.createMirror(addr, wanted)
-		c.Mutex.Lock()
-		if err != nil {
+		if single {
+			// A slot for each task waiting for such a
+			// worker; the worker caps it at what it has
+			// free.
+			wanted = c.master.running.waiting(selector)
+			if wanted < 1 {
+				wanted = 1
+			}
+		}
+		if err := c.connect(addr, wanted); err != nil {
 			delete(c.workers, addr)
 			log.Println("nonfatal error creating mirror:", err)
-		} else {
-			// This could happen in the unlikely event of
-			// the workers having more capacity than our
-			// parallelism.
-			if _, ok := c.mirrors[addr]; ok {
-				log.Panicf("already have this mirror: %v", addr)
-			}
-			mc.workerAddr = addr
-			c.mirrors[addr] = mc
-			c.master.attributes.AddClient(mc)
+		} else if single {
+			break
 		}
 	}
 }
+
+// connect creates a mirror on the worker. Must hold the lock; it is
+// released while connecting.
+func (c *mirrorConnections) connect(addr string, jobs int) error {
+	c.Mutex.Unlock()
+	log.Printf("Creating mirror on %v, requesting %d jobs", addr, jobs)
+	mc, err := c.master.createMirror(addr, jobs)
+	c.Mutex.Lock()
+	if err != nil {
+		return err
+	}
+
+	// This could happen in the unlikely event of
+	// the workers having more capacity than our
+	// parallelism.
+	if _, ok := c.mirrors[addr]; ok {
+		log.Panicf("already have this mirror: %v", addr)
+	}
+	mc.workerAddr = addr
+	c.mirrors[addr] = mc
+	c.master.attributes.AddClient(mc)
+	return nil
+}
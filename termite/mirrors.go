@@ -62,6 +62,19 @@ func (wm *WorkerMirrors) getMirror(rpcConn, revConn, contentConn, revContentConn
 	return mirror, nil
 }
 
+// shrink lowers the job count of the mirror. Running tasks finish,
+// but no new ones start until the mirror is below the new count.
+func (wm *WorkerMirrors) shrink(mirror *Mirror, jobs int) {
+	wm.mirrorMapMutex.Lock()
+	defer wm.mirrorMapMutex.Unlock()
+	mirror.fsMutex.Lock()
+	defer mirror.fsMutex.Unlock()
+	if jobs > 0 && jobs < mirror.maxJobCount {
+		log.Printf("Shrinking mirror %s from %d to %d jobs", mirror.key, mirror.maxJobCount, jobs)
+		mirror.maxJobCount = jobs
+	}
+}
+
 func (wm *WorkerMirrors) DropMirror(mirror *Mirror) {
 	wm.mirrorMapMutex.Lock()
 	defer wm.mirrorMapMutex.Unlock()
@@ -107,3 +120,17 @@ func (wm *WorkerMirrors) Status(req *WorkerStatusRequest, rep *WorkerStatusRespo
 		rep.MirrorStatus = append(rep.MirrorStatus, mRep)
 	}
 }
+
+// liveHashes returns the content referenced by the attribute caches
+// of the mirrors.
+func (wm *WorkerMirrors) liveHashes() map[string]bool {
+	keep := map[string]bool{}
+	for _, m := range wm.mirrors() {
+		for _, a := range m.rpcFs.attr.Copy().Files {
+			if a.Hash != "" {
+				keep[a.Hash] = true
+			}
+		}
+	}
+	return keep
+}
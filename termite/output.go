@@ -0,0 +1,196 @@
+package termite
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Streamed output up to this size can be stored in the action cache.
+const maxCapturedOutput = 1 << 20
+
+// outputTunnel collects a stream of task output from a worker, for a
+// single attempt. The output is only forwarded to the shell-wrapper
+// once the result of the attempt is used, so attempts that are
+// retried or lose a race never print anything.
+type outputTunnel struct {
+	wg sync.WaitGroup
+
+	mutex sync.Mutex
+	src   io.ReadWriteCloser
+	buf   bytes.Buffer
+}
+
+// open starts collecting from a new channel on the worker connection,
+// and returns the id of the channel.
+func (t *outputTunnel) open(mux connMuxer) (string, error) {
+	id := ConnectionId()
+	src, err := mux.Open(id)
+	if err != nil {
+		return "", err
+	}
+
+	t.mutex.Lock()
+	t.src = src
+	t.mutex.Unlock()
+
+	t.wg.Add(1)
+	go func() {
+		io.Copy(t, src)
+		src.Close()
+		t.wg.Done()
+	}()
+	return id, nil
+}
+
+func (t *outputTunnel) Write(b []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.buf.Write(b)
+}
+
+// wait waits for the worker to finish the stream. If the attempt
+// failed, the worker may never close its end, so we close it
+// ourselves.
+func (t *outputTunnel) wait(failed bool) {
+	t.mutex.Lock()
+	src := t.src
+	t.mutex.Unlock()
+	if failed && src != nil {
+		src.Close()
+	}
+	t.wg.Wait()
+}
+
+func (t *outputTunnel) output() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.buf.String()
+}
+
+// attemptOutput holds the streams of a single attempt to run a
+// task. Streams that the wrapper did not ask for are nil.
+type attemptOutput struct {
+	stdout *outputTunnel
+	stderr *outputTunnel
+}
+
+// open sets up the streams for running req on the worker at addr.
+func (a *attemptOutput) open(dialer connDialer, addr string, req *WorkRequest) error {
+	req.StdoutId = ""
+	req.StderrId = ""
+	if a.stdout == nil && a.stderr == nil {
+		return nil
+	}
+	mux, err := dialer.Dial(addr)
+	if err != nil {
+		return err
+	}
+	if a.stdout != nil {
+		if req.StdoutId, err = a.stdout.open(mux); err != nil {
+			return err
+		}
+	}
+	if a.stderr != nil {
+		if req.StderrId, err = a.stderr.open(mux); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *attemptOutput) wait(failed bool) {
+	for _, t := range []*outputTunnel{a.stdout, a.stderr} {
+		if t != nil {
+			t.wait(failed)
+		}
+	}
+}
+
+// taskOutput holds the output streams of a task to the wrapper, and
+// the attempt whose output goes there. Streams that the wrapper did
+// not ask for are nil.
+type taskOutput struct {
+	stdout io.WriteCloser
+	stderr io.WriteCloser
+
+	mutex sync.Mutex
+	used  *attemptOutput
+}
+
+// newTaskOutput takes the output connections from req.
+func newTaskOutput(req *WorkRequest) *taskOutput {
+	o := &taskOutput{
+		stdout: req.StdoutConn,
+		stderr: req.StderrConn,
+	}
+	req.StdoutConn = nil
+	req.StderrConn = nil
+	return o
+}
+
+// attempt returns the streams for a new attempt.
+func (o *taskOutput) attempt() *attemptOutput {
+	a := &attemptOutput{}
+	if o.stdout != nil {
+		a.stdout = &outputTunnel{}
+	}
+	if o.stderr != nil {
+		a.stderr = &outputTunnel{}
+	}
+	return a
+}
+
+// use records that the result of the task comes from attempt a. Its
+// output replaces that of attempts used before.
+func (o *taskOutput) use(a *attemptOutput) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.used = a
+}
+
+// streams returns the collected output of the used attempt, for the
+// streams the wrapper asked for.
+func (o *taskOutput) streams() (stdout, stderr *outputTunnel) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.used == nil {
+		return nil, nil
+	}
+	return o.used.stdout, o.used.stderr
+}
+
+// response returns rep with the streamed output filled in, for
+// storing in the action cache. It returns false if the output was
+// too large to store.
+func (o *taskOutput) response(rep *WorkResponse) (*WorkResponse, bool) {
+	full := *rep
+	stdout, stderr := o.streams()
+	if stdout != nil {
+		full.Stdout = stdout.output()
+	}
+	if stderr != nil {
+		full.Stderr = stderr.output()
+	}
+	ok := len(full.Stdout) <= maxCapturedOutput && len(full.Stderr) <= maxCapturedOutput
+	return &full, ok
+}
+
+// Close forwards the output of the used attempt, and ends the
+// streams to the wrapper.
+func (o *taskOutput) Close() {
+	stdout, stderr := o.streams()
+	for _, s := range []struct {
+		dest io.WriteCloser
+		src  *outputTunnel
+	}{{o.stdout, stdout}, {o.stderr, stderr}} {
+		if s.dest == nil {
+			continue
+		}
+		if s.src != nil {
+			// Errors from the wrapper side are ignored.
+			io.WriteString(s.dest, s.src.output())
+		}
+		s.dest.Close()
+	}
+}
@@ -0,0 +1,112 @@
+package termite
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestTaskOutput(t *testing.T) {
+	secret := make([]byte, 20)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("net.Listen", err)
+	}
+	defer l.Close()
+	listener := newWorkerListener(l, secret)
+
+	stdout := &closeBuffer{}
+	req := &WorkRequest{StdoutConn: stdout}
+	out := newTaskOutput(req)
+	if req.StdoutConn != nil {
+		t.Fatalf("connection left in request")
+	}
+
+	// The first attempt fails without the worker accepting.
+	dialer := newWorkerDialer(secret)
+	first := out.attempt()
+	if err := first.open(dialer, l.Addr().String(), req); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if req.StdoutId == "" || req.StderrId != "" {
+		t.Fatalf("got ids %q %q", req.StdoutId, req.StderrId)
+	}
+	first.wait(true)
+	out.use(first)
+
+	second := out.attempt()
+	if err := second.open(dialer, l.Addr().String(), req); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	conn := listener.Pending().accept(req.StdoutId)
+	conn.Write([]byte("hello"))
+	conn.Close()
+	second.wait(false)
+	out.use(second)
+
+	rep := &WorkResponse{Stderr: "err"}
+	full, ok := out.response(rep)
+	if !ok || full.Stdout != "hello" || full.Stderr != "err" {
+		t.Errorf("got %q %q %v, want hello, err", full.Stdout, full.Stderr, ok)
+	}
+	if rep.Stdout != "" {
+		t.Errorf("response modified: %q", rep.Stdout)
+	}
+
+	out.Close()
+	if !stdout.closed {
+		t.Errorf("stream to wrapper not closed")
+	}
+	if got := stdout.String(); got != "hello" {
+		t.Errorf("streamed %q, want %q", got, "hello")
+	}
+}
+
+func TestTaskOutputDiscardedAttempt(t *testing.T) {
+	secret := make([]byte, 20)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("net.Listen", err)
+	}
+	defer l.Close()
+	listener := newWorkerListener(l, secret)
+	dialer := newWorkerDialer(secret)
+
+	stdout := &closeBuffer{}
+	req := &WorkRequest{StdoutConn: stdout}
+	out := newTaskOutput(req)
+	run := func(output string) {
+		a := out.attempt()
+		if err := a.open(dialer, l.Addr().String(), req); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		conn := listener.Pending().accept(req.StdoutId)
+		conn.Write([]byte(output))
+		conn.Close()
+		a.wait(false)
+		out.use(a)
+	}
+
+	// The first attempt prints a warning, but is discarded
+	// along with a task sharing its file system, and retried.
+	run("warning: unused variable\n")
+	if got := stdout.String(); got != "" {
+		t.Fatalf("output of unfinished task forwarded: %q", got)
+	}
+	run("warning: unused variable, again\n")
+
+	out.Close()
+	if got, want := stdout.String(), "warning: unused variable, again\n"; got != want {
+		t.Errorf("streamed %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,216 @@
+package termite
+
+import (
+	"log"
+	"net/rpc"
+	"sync"
+
+	"github.com/hanwen/termite/cba"
+)
+
+// Number of workers to remember for each hash.
+const maxContentLocations = 4
+
+// contentLocations remembers which workers have which content, so
+// workers can fetch from each other rather than over the master's
+// uplink.
+type contentLocations struct {
+	mutex sync.Mutex
+	cache *cba.LruCache
+}
+
+type locationList struct {
+	// Most recent first.
+	addrs []string
+}
+
+func newContentLocations(size int) *contentLocations {
+	return &contentLocations{
+		cache: cba.NewLruCache(size),
+	}
+}
+
+// add records that the worker at addr has the given content.
+func (l *contentLocations) add(hash string, addr string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	v := l.cache.Get(hash)
+	if v == nil {
+		l.cache.Add(hash, &locationList{addrs: []string{addr}})
+		return
+	}
+
+	list := v.(*locationList)
+	for _, a := range list.addrs {
+		if a == addr {
+			return
+		}
+	}
+	list.addrs = append([]string{addr}, list.addrs...)
+	if len(list.addrs) > maxContentLocations {
+		list.addrs = list.addrs[:maxContentLocations]
+	}
+}
+
+// get returns the workers known to have the content.
+func (l *contentLocations) get(hash string) []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	v := l.cache.Get(hash)
+	if v == nil {
+		return nil
+	}
+	return append([]string{}, v.(*locationList).addrs...)
+}
+
+// contentLocator is the RPC service that a worker uses to find peers
+// holding content.
+type contentLocator struct {
+	master *Master
+
+	// The worker asking.
+	addr string
+}
+
+func (l *contentLocator) Locate(req *ContentLocateRequest, rep *ContentLocateResponse) error {
+	for _, a := range l.master.locations.get(req.Hash) {
+		if a != l.addr && l.master.mirrors.connected(a) {
+			rep.Peers = append(rep.Peers, a)
+		}
+	}
+
+	// The asking worker will have the content once it is done
+	// fetching.
+	l.master.locations.add(req.Hash, l.addr)
+	return nil
+}
+
+// contentPeers fetches content from the stores of other workers.
+type contentPeers struct {
+	store  *cba.Store
+	dialer connDialer
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	clients  map[string]*cba.Client
+	fetching map[string]bool
+}
+
+func newContentPeers(store *cba.Store, dialer connDialer) *contentPeers {
+	p := &contentPeers{
+		store:    store,
+		dialer:   dialer,
+		clients:  map[string]*cba.Client{},
+		fetching: map[string]bool{},
+	}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+func (p *contentPeers) connect(addr string) (*cba.Client, error) {
+	mux, err := p.dialer.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := mux.Open(RPC_CHANNEL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	id := ConnectionId()
+	contentConn, err := mux.Open(id)
+	if err != nil {
+		return nil, err
+	}
+
+	req := ContentConnectRequest{ContentId: id}
+	rep := ContentConnectResponse{}
+	cl := rpc.NewClient(conn)
+	err = cl.Call("Worker.ServeContent", &req, &rep)
+	cl.Close()
+	if err != nil {
+		contentConn.Close()
+		return nil, err
+	}
+	return p.store.NewClient(contentConn), nil
+}
+
+func (p *contentPeers) client(addr string) (*cba.Client, error) {
+	p.mutex.Lock()
+	cl := p.clients[addr]
+	p.mutex.Unlock()
+	if cl != nil {
+		return cl, nil
+	}
+
+	cl, err := p.connect(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if existing := p.clients[addr]; existing != nil {
+		cl.Close()
+		return existing, nil
+	}
+	p.clients[addr] = cl
+	return cl, nil
+}
+
+func (p *contentPeers) drop(addr string, cl *cba.Client) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.clients[addr] == cl {
+		delete(p.clients, addr)
+	}
+	cl.Close()
+}
+
+// FetchOnce tries to fetch content from one of the peers returned by
+// locate. Concurrent calls for the same hash are coalesced. It
+// returns whether the content is in the store afterwards.
+func (p *contentPeers) FetchOnce(hash string, size int64, locate func() []string) bool {
+	p.mutex.Lock()
+	for !p.store.Has(hash) && p.fetching[hash] {
+		p.cond.Wait()
+	}
+	if p.store.Has(hash) {
+		p.mutex.Unlock()
+		return true
+	}
+	p.fetching[hash] = true
+	p.mutex.Unlock()
+
+	got := p.fetch(locate(), hash, size)
+
+	p.mutex.Lock()
+	delete(p.fetching, hash)
+	p.cond.Broadcast()
+	p.mutex.Unlock()
+	return got
+}
+
+func (p *contentPeers) fetch(peers []string, hash string, size int64) bool {
+	for _, addr := range peers {
+		cl, err := p.client(addr)
+		if err != nil {
+			log.Printf("connecting to peer %s: %v", addr, err)
+			continue
+		}
+		got, err := cl.FetchOnce(hash, size)
+		if err != nil {
+			log.Printf("fetching %x from peer %s: %v", hash, addr, err)
+			p.drop(addr, cl)
+			continue
+		}
+		if got {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package termite
+
+import (
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"os"
+	"testing"
+
+	"github.com/hanwen/termite/cba"
+)
+
+func TestContentLocations(t *testing.T) {
+	l := newContentLocations(10)
+	if got := l.get("h"); got != nil {
+		t.Errorf("empty: got %v", got)
+	}
+
+	l.add("h", "a")
+	l.add("h", "b")
+	l.add("h", "a")
+	if got := l.get("h"); len(got) != 2 || got[0] != "b" || got[1] != "a" {
+		t.Errorf("got %v, want [b a]", got)
+	}
+
+	for _, a := range []string{"c", "d", "e", "f"} {
+		l.add("h", a)
+	}
+	if got := l.get("h"); len(got) != maxContentLocations || got[0] != "f" {
+		t.Errorf("got %v, want %d entries starting with f", got, maxContentLocations)
+	}
+}
+
+func TestContentPeers(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	secret := make([]byte, 20)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("net.Listen", err)
+	}
+	defer l.Close()
+
+	peer := &Worker{
+		content:  cba.NewStore(&cba.StoreOptions{Dir: dir + "/peer"}, nil),
+		listener: newWorkerListener(l, secret),
+	}
+	rs := rpc.NewServer()
+	rs.RegisterName("Worker", (*WorkerService)(peer))
+	go func() {
+		for c := range peer.listener.Pending().rpcChan() {
+			go rs.ServeConn(c)
+		}
+	}()
+
+	content := []byte("hello")
+	hash := peer.content.Save(content)
+
+	store := cba.NewStore(&cba.StoreOptions{Dir: dir + "/store"}, nil)
+	p := newContentPeers(store, newWorkerDialer(secret))
+
+	// The first peer is unreachable, the second has the content.
+	peers := []string{"localhost:1", l.Addr().String()}
+	if !p.FetchOnce(hash, int64(len(content)), func() []string { return peers }) {
+		t.Fatalf("FetchOnce failed")
+	}
+	if !store.Has(hash) {
+		t.Errorf("content not in store after fetch")
+	}
+
+	missing := peer.content.HashType().New().Sum(nil)
+	if p.FetchOnce(string(missing), 0, func() []string { return peers }) {
+		t.Errorf("FetchOnce succeeded for content the peer does not have")
+	}
+}
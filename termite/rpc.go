@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"io"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/termite/attr"
+	"github.com/hanwen/termite/cba"
 	"github.com/hanwen/termite/stats"
 )
 
@@ -45,6 +47,7 @@ type WorkerStatusResponse struct {
 	Version      string
 	MaxJobCount  int
 	Accepting    bool
+	Draining     bool
 
 	// In chronological order.
 	CpuStats  []stats.CpuStat
@@ -54,11 +57,20 @@ type WorkerStatusResponse struct {
 	PhaseNames  []string
 	PhaseCounts []int
 	MemStat     stats.MemStat
+
+	// Of the machine.
+	Cpus         int
+	MemAvailable stats.MemCounter
+
+	ContentStats cba.GCStats
 }
 
+// Timing is a phase of running a task. Times are in seconds; Start
+// is relative to the start of the task.
 type Timing struct {
-	Name string
-	Dt   float64
+	Name  string
+	Dt    float64
+	Start float64
 }
 
 type WorkResponse struct {
@@ -79,18 +91,45 @@ type WorkResponse struct {
 
 	// Worker where this was processed.
 	WorkerId string
+
+	// Set if the task was killed for going over its memory
+	// limit.
+	OomKilled bool
+
+	// Set if the task was killed for running past its timeout.
+	TimedOut bool
+
+	// Set if the results for TaskIds were thrown away, because one
+	// of the tasks was cancelled.
+	Discarded bool
+
+	// The master's attributes as sent to the worker, to hash the
+	// files the task read. Not sent over RPC.
+	inputs *attr.Frozen
 }
 
 type WorkRequest struct {
 	// Unique id of this request.
 	TaskId int
 
+	// Chosen by the wrapper, which doesn't know the TaskId, so it
+	// can cancel the request.
+	ClientId string
+
 	// Id of connection streaming stdin.
 	StdinId string
 
 	// TODO - don't abuse RPC message for transporting this.
 	StdinConn io.ReadWriteCloser
 
+	// Ids of connections streaming stdout and stderr back while the
+	// task runs. If unset, output is returned in the WorkResponse.
+	StdoutId string
+	StderrId string
+
+	StdoutConn io.ReadWriteCloser
+	StderrConn io.ReadWriteCloser
+
 	Debug  bool
 	Binary string
 	Argv   []string
@@ -106,6 +145,15 @@ type WorkRequest struct {
 	// If set, must run on this worker. Used for debugging.
 	Worker string
 
+	// Overrides the worker's default limits.
+	Limits ResourceLimits
+
+	// Only run on workers with these labels.
+	Labels Labels
+
+	// If positive, the task is killed after running this long.
+	Timeout time.Duration
+
 	// The following is used with TrackReads and can be injected from the Makefile.
 	DeclaredDeps   []string
 	DeclaredTarget string
@@ -115,6 +163,35 @@ func (r *WorkRequest) Summary() string {
 	return fmt.Sprintf("Stdin %s Cmd %s Id %d", r.StdinId, r.Argv, r.TaskId)
 }
 
+type CancelRequest struct {
+	// Set by the wrapper.
+	ClientId string
+
+	// Set by the master when forwarding to a worker.
+	TaskId int
+}
+
+type CancelResponse struct {
+	Found bool
+}
+
+// ShrinkRequest lowers the number of jobs a mirror may run, so the
+// worker can give the slots to other masters.
+type ShrinkRequest struct {
+	MaxJobCount int
+}
+
+type ShrinkResponse struct {
+}
+
+type AbortAllRequest struct {
+}
+
+type AbortAllResponse struct {
+	// Number of tasks cancelled.
+	Cancelled int
+}
+
 type CreateMirrorRequest struct {
 	// Ids of connections to use for RPC
 	RpcId        string
@@ -133,6 +210,60 @@ type CreateMirrorResponse struct {
 	GrantedJobCount int
 }
 
+type ContentLocateRequest struct {
+	Hash string
+}
+
+type ContentLocateResponse struct {
+	// Addresses of workers that may have the content.
+	Peers []string
+}
+
+type ContentConnectRequest struct {
+	// Id of the connection to serve content on.
+	ContentId string
+}
+
+type ContentConnectResponse struct {
+}
+
+type CacheConnectRequest struct {
+	// Ids of connections to use for RPC and content.
+	RpcId        string
+	ContentId    string
+	RevContentId string
+}
+
+type CacheConnectResponse struct {
+}
+
+// ActionBlob identifies content in the store.
+type ActionBlob struct {
+	Hash string
+	Size int64
+}
+
+type ActionGetRequest struct {
+	Key string
+}
+
+type ActionGetResponse struct {
+	// Empty if the key is unknown.
+	ActionBlob
+}
+
+type ActionPutRequest struct {
+	Key string
+	ActionBlob
+
+	// Content that should be uploaded before the key is
+	// stored. Should include ActionBlob.
+	Blobs []ActionBlob
+}
+
+type ActionPutResponse struct {
+}
+
 type ShutdownRequest struct {
 	Restart bool
 	Kill    bool
@@ -141,6 +272,20 @@ type ShutdownRequest struct {
 type ShutdownResponse struct {
 }
 
+// DrainRequest asks a worker to finish its running tasks, and refuse
+// new ones.
+type DrainRequest struct {
+	// Shut down once the running tasks are done, restarting if
+	// Restart is set too.
+	Shutdown bool
+	Restart  bool
+}
+
+type DrainResponse struct {
+	// Tasks that were still running.
+	Running int
+}
+
 type LogRequest struct {
 	Whence int
 	Off    int64
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/rpc"
 
 	"github.com/hanwen/go-fuse/fuse"
 	"github.com/hanwen/go-fuse/fuse/nodefs"
@@ -22,6 +23,11 @@ type RpcFs struct {
 	timings *stats.TimerStats
 	attr    *attr.AttributeCache
 	id      string
+
+	// If set, content is fetched from other workers if possible,
+	// using locator to ask the master for their addresses.
+	peers   *contentPeers
+	locator *rpc.Client
 }
 
 func NewRpcFs(attrClient *attr.Client, cache *cba.Store, contentConn io.ReadWriteCloser) *RpcFs {
@@ -52,6 +58,11 @@ func (fs *RpcFs) Close() {
 }
 
 func (fs *RpcFs) FetchHash(a *attr.FileAttr) error {
+	if fs.peers != nil && fs.peers.FetchOnce(a.Hash, int64(a.Size),
+		func() []string { return fs.locate(a.Hash) }) {
+		return nil
+	}
+
 	got, e := fs.contentClient.FetchOnce(a.Hash, int64(a.Size))
 	if e == nil && !got {
 		log.Fatalf("Did not have hash %x for %s", a.Hash, a.Path)
@@ -59,6 +70,17 @@ func (fs *RpcFs) FetchHash(a *attr.FileAttr) error {
 	return e
 }
 
+// locate asks the master which workers have the content.
+func (fs *RpcFs) locate(hash string) []string {
+	req := ContentLocateRequest{Hash: hash}
+	rep := ContentLocateResponse{}
+	if err := fs.locator.Call("Locator.Locate", &req, &rep); err != nil {
+		log.Printf("Locate %x: %v", hash, err)
+		return nil
+	}
+	return rep.Peers
+}
+
 func (fs *RpcFs) Update(req *UpdateRequest, resp *UpdateResponse) error {
 	fs.updateFiles(req.Files)
 	return nil
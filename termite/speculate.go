@@ -0,0 +1,155 @@
+package termite
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hanwen/termite/cba"
+)
+
+// Tasks are never duplicated before they ran this long, as the
+// duplicate would not save much.
+const minSpeculationDelay = 2 * time.Second
+
+// durationHistory remembers how long commands usually take.
+type durationHistory struct {
+	mutex sync.Mutex
+	cache *cba.LruCache
+}
+
+type durationEstimate struct {
+	d time.Duration
+}
+
+func newDurationHistory(size int) *durationHistory {
+	return &durationHistory{
+		cache: cba.NewLruCache(size),
+	}
+}
+
+func durationKey(req *WorkRequest) string {
+	return strings.Join(append([]string{req.Dir, req.Binary}, req.Argv...), "\x00")
+}
+
+// add records a run of the command. The estimate averages the recent
+// runs.
+func (h *durationHistory) add(req *WorkRequest, d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	key := durationKey(req)
+	if v := h.cache.Get(key); v != nil {
+		e := v.(*durationEstimate)
+		e.d = (e.d + d) / 2
+		return
+	}
+	h.cache.Add(key, &durationEstimate{d})
+}
+
+// expected returns how long the command usually takes, or false if
+// we haven't seen it before.
+func (h *durationHistory) expected(req *WorkRequest) (time.Duration, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	v := h.cache.Get(durationKey(req))
+	if v == nil {
+		return 0, false
+	}
+	return v.(*durationEstimate).d, true
+}
+
+type attemptResult struct {
+	mirror *mirrorConnection
+	rep    *WorkResponse
+	out    *attemptOutput
+	err    error
+	dt     time.Duration
+}
+
+// runSpeculatively runs the request on a worker. If it takes much
+// longer than usual, and another worker is idle, a duplicate is
+// started there, and the first result wins.
+func (m *Master) runSpeculatively(req *WorkRequest, rep *WorkResponse, out *taskOutput) error {
+	expected, ok := m.durations.expected(req)
+	// Only the output of the attempt that wins is forwarded.
+	if m.options.SpeculationFactor <= 0 || !ok || req.StdinId != "" {
+		start := time.Now()
+		err := m.runOnce(req, rep, out)
+		if err == nil {
+			m.durations.add(req, time.Now().Sub(start))
+		}
+		return err
+	}
+
+	mirror, err := m.mirrors.pick(m.affinity(req), req.Labels)
+	if err != nil {
+		return err
+	}
+	// Copy before the first attempt starts modifying req.
+	dupReq := *req
+	results := make(chan attemptResult, 2)
+	attempt := func(mc *mirrorConnection, req *WorkRequest, rep *WorkResponse) {
+		a := out.attempt()
+		start := time.Now()
+		err := m.runAttempt(mc, req, rep, a)
+		results <- attemptResult{mc, rep, a, err, time.Now().Sub(start)}
+	}
+	go attempt(mirror, req, rep)
+
+	delay := time.Duration(float64(expected) * m.options.SpeculationFactor)
+	if delay < minSpeculationDelay {
+		delay = minSpeculationDelay
+	}
+	timer := time.NewTimer(delay)
+
+	var dup *mirrorConnection
+	select {
+	case r := <-results:
+		timer.Stop()
+		out.use(r.out)
+		if r.err == nil {
+			m.durations.add(req, r.dt)
+		}
+		return r.err
+	case <-timer.C:
+		dup = m.mirrors.pickIdle(mirror, req.Labels)
+	}
+	if dup == nil {
+		r := <-results
+		out.use(r.out)
+		if r.err == nil {
+			m.durations.add(req, r.dt)
+		}
+		return r.err
+	}
+
+	log.Printf("Task %d is slow on %s after %v, duplicating on %s",
+		req.TaskId, mirror.workerAddr, delay, dup.workerAddr)
+	m.timing.Log("Master.Speculate", delay)
+
+	dupRep := &WorkResponse{}
+	go attempt(dup, &dupReq, dupRep)
+
+	// The first attempt to finish cancels the other before
+	// replaying its results. Wait for both, so the loser doesn't
+	// write into rep anymore.
+	first := <-results
+	second := <-results
+	if second.err == nil && (first.err != nil || first.rep.TimedOut) {
+		first = second
+	}
+	out.use(first.out)
+	if first.err != nil {
+		return first.err
+	}
+
+	log.Printf("Task %d: using the result from %s", req.TaskId, first.mirror.workerAddr)
+	m.durations.add(req, first.dt)
+	if first.mirror == dup {
+		m.timing.Log("Master.SpeculationWon", first.dt)
+		*rep = *dupRep
+	}
+	return nil
+}
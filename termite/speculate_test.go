@@ -0,0 +1,87 @@
+package termite
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDurationHistory(t *testing.T) {
+	h := newDurationHistory(10)
+	cc := &WorkRequest{Binary: "/usr/bin/cc", Argv: []string{"cc", "-c", "a.c"}, Dir: "/src"}
+	other := &WorkRequest{Binary: "/usr/bin/cc", Argv: []string{"cc", "-c", "b.c"}, Dir: "/src"}
+
+	if _, ok := h.expected(cc); ok {
+		t.Errorf("expected duration for unseen command")
+	}
+	h.add(cc, 2*time.Second)
+	h.add(cc, 4*time.Second)
+	if d, ok := h.expected(cc); !ok || d != 3*time.Second {
+		t.Errorf("got %v %v, want 3s", d, ok)
+	}
+	if _, ok := h.expected(other); ok {
+		t.Errorf("expected duration for other command")
+	}
+}
+
+func TestMirrorConnectionsPickIdle(t *testing.T) {
+	busy := &mirrorConnection{workerAddr: "busy", maxJobs: 1}
+	idle := &mirrorConnection{workerAddr: "idle", maxJobs: 1, availableJobs: 1}
+	c := &mirrorConnections{
+		mirrors: map[string]*mirrorConnection{"busy": busy, "idle": idle},
+	}
+	if got := c.pickIdle(idle, nil); got != nil {
+		t.Errorf("got %v, want nil", got.workerAddr)
+	}
+	if got := c.pickIdle(busy, nil); got != idle {
+		t.Errorf("got %v, want idle", got)
+	}
+	if idle.availableJobs != 0 {
+		t.Errorf("job slot not taken")
+	}
+}
+
+func TestSpeculationOutput(t *testing.T) {
+	secret := make([]byte, 20)
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal("net.Listen", err)
+	}
+	defer l.Close()
+	listener := newWorkerListener(l, secret)
+	dialer := newWorkerDialer(secret)
+
+	stdout := &closeBuffer{}
+	req := &WorkRequest{StdoutConn: stdout}
+	out := newTaskOutput(req)
+	dupReq := *req
+
+	// Both runs print while they run; the duplicate wins.
+	first, dup := out.attempt(), out.attempt()
+	for _, r := range []struct {
+		a      *attemptOutput
+		req    *WorkRequest
+		output string
+	}{{first, req, "slow\n"}, {dup, &dupReq, "fast\n"}} {
+		if err := r.a.open(dialer, l.Addr().String(), r.req); err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		conn := listener.Pending().accept(r.req.StdoutId)
+		conn.Write([]byte(r.output))
+		conn.Close()
+	}
+	if req.StdoutId == dupReq.StdoutId {
+		t.Fatalf("attempts share stream %q", req.StdoutId)
+	}
+	first.wait(false)
+	dup.wait(false)
+	out.use(dup)
+
+	if full, ok := out.response(&WorkResponse{}); !ok || full.Stdout != "fast\n" {
+		t.Errorf("got %q %v for the action cache, want fast", full.Stdout, ok)
+	}
+	out.Close()
+	if got := stdout.String(); got != "fast\n" {
+		t.Errorf("streamed %q, want only the winner's output", got)
+	}
+}
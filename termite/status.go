@@ -1,6 +1,8 @@
 package termite
 
 import (
+	"runtime"
+
 	"github.com/hanwen/termite/stats"
 )
 
@@ -29,11 +31,15 @@ func (w *Worker) Status(req *WorkerStatusRequest, rep *WorkerStatusResponse) err
 	rep.MaxJobCount = w.options.Jobs
 	rep.Version = Version()
 	rep.Accepting = w.accepting
+	rep.Draining = w.isDraining()
 	rep.CpuStats = w.stats.CpuStats()
 	rep.DiskStats = w.stats.DiskStats()
 	rep.PhaseCounts = w.stats.PhaseCounts()
 	rep.PhaseNames = w.stats.PhaseOrder
 	rep.TotalCpu = *stats.TotalCpuStat()
 	rep.MemStat = *stats.GetMemStat()
+	rep.Cpus = runtime.NumCPU()
+	rep.MemAvailable = stats.GetMemAvailable()
+	rep.ContentStats = w.content.GCStats()
 	return nil
 }
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/hanwen/termite/attr"
 	"github.com/hanwen/termite/fastpath"
@@ -22,14 +23,48 @@ type WorkerTask struct {
 	stdinConn io.ReadWriteCloser
 	mirror    *Mirror
 	cmd       *exec.Cmd
+	cgroup    *taskCgroup
 	taskInfo  string
+	start     time.Time
+
+	// If set, output is streamed to the master rather than returned
+	// in rep.
+	stdoutConn io.ReadWriteCloser
+	stderrConn io.ReadWriteCloser
+
+	// Protected by Mirror.fsMutex.
+	cancelled bool
+	timedOut  bool
 }
 
 func (t *WorkerTask) Kill() {
-	if t.cmd.Process != nil {
+	t.signal(syscall.SIGQUIT)
+}
+
+// signal sends sig to the task and the processes it forked, which
+// share its process group.
+func (t *WorkerTask) signal(sig syscall.Signal) {
+	if t.cmd != nil && t.cmd.Process != nil {
 		pid := t.cmd.Process.Pid
-		err := syscall.Kill(pid, syscall.SIGQUIT)
-		log.Printf("Killed pid %d, result %v", pid, err)
+		err := syscall.Kill(-pid, sig)
+		log.Printf("Killed process group %d with %v, result %v", pid, sig, err)
+	}
+}
+
+// timeout kills the task for running too long. Its file system may
+// hold partial results, so they are thrown away.
+func (t *WorkerTask) timeout(state *workerFSState) {
+	t.mirror.fsMutex.Lock()
+	defer t.mirror.fsMutex.Unlock()
+	log.Printf("Task %d timed out after %v: %v", t.req.TaskId, t.req.Timeout, t)
+	t.timedOut = true
+	state.cancelled = true
+	t.signal(syscall.SIGKILL)
+	if t.cgroup != nil {
+		// Also catches processes that left the process group.
+		if err := t.cgroup.kill(); err != nil {
+			log.Printf("Killing cgroup of task %d: %v", t.req.TaskId, err)
+		}
 	}
 }
 
@@ -37,7 +72,20 @@ func (t *WorkerTask) String() string {
 	return t.taskInfo
 }
 
+// closeOutput ends the output streams, if any.
+func (t *WorkerTask) closeOutput() {
+	if t.stdoutConn != nil {
+		t.stdoutConn.Close()
+		t.stdoutConn = nil
+	}
+	if t.stderrConn != nil {
+		t.stderrConn.Close()
+		t.stderrConn = nil
+	}
+}
+
 func (t *WorkerTask) Run() error {
+	defer t.closeOutput()
 	fsState, err := t.mirror.newFs(t)
 	if err == ShuttingDownError {
 		// We can't return an error, since that would cause
@@ -53,13 +101,21 @@ func (t *WorkerTask) Run() error {
 		return err
 	}
 
+	start := time.Now()
 	t.mirror.worker.stats.Enter("fuse")
 	err = t.runInFuse(fsState)
 	t.mirror.worker.stats.Exit("fuse")
+	t.addTiming("fuse", start)
 
+	start = time.Now()
 	t.mirror.worker.stats.Enter("reap")
 	if t.mirror.considerReap(fsState, t) {
-		t.rep.FileSet, t.rep.TaskIds, t.rep.Reads = t.mirror.reapFuse(fsState)
+		if fsState.cancelled {
+			t.rep.TaskIds = t.mirror.discardFuse(fsState)
+			t.rep.Discarded = true
+		} else {
+			t.rep.FileSet, t.rep.TaskIds, t.rep.Reads = t.mirror.reapFuse(fsState)
+		}
 	} else {
 		t.mirror.returnFS(fsState)
 	}
@@ -68,10 +124,16 @@ func (t *WorkerTask) Run() error {
 		t.rep.Reads = nil
 	}
 	t.mirror.worker.stats.Exit("reap")
+	t.addTiming("reap", start)
 
 	return err
 }
 
+// addTiming records a phase of the task that started at start.
+func (t *WorkerTask) addTiming(name string, start time.Time) {
+	t.rep.Timings = append(t.rep.Timings, newTiming(name, t.start, start))
+}
+
 func (t *WorkerTask) runInFuse(state *workerFSState) error {
 	state.fs.SetDebug(t.req.Debug)
 	stdout := &bytes.Buffer{}
@@ -139,45 +201,124 @@ func (t *WorkerTask) runInFuse(state *workerFSState) error {
 	)
 
 	args = append(args, t.req.Argv...)
-	t.cmd = &exec.Cmd{
+	cmd := &exec.Cmd{
 		Path: args[0],
 		Args: args,
 	}
-	cmd := t.cmd
+	setupTaskCmd(cmd)
 	cmd.Env = t.req.Env
 	cmd.Stdout = stdout
+	if t.stdoutConn != nil {
+		cmd.Stdout = t.stdoutConn
+	}
 	cmd.Stderr = stderr
+	if t.stderrConn != nil {
+		cmd.Stderr = t.stderrConn
+	}
 	if t.stdinConn != nil {
 		cmd.Stdin = t.stdinConn
 	}
 
-	if err := cmd.Start(); err != nil {
+	// Limits are set up before starting, so no process of the task
+	// runs outside the cgroup. If they can't be enforced, the task
+	// doesn't run.
+	var cgroup *taskCgroup
+	var cgroupDir *os.File
+	options := t.mirror.worker.options
+	if limits := t.req.Limits.merge(options.Limits); options.CgroupRoot != "" && !limits.empty() {
+		cgroup, err = newTaskCgroup(options.CgroupRoot, limits)
+		if err == nil {
+			cgroupDir, err = cgroup.setProcAttr(cmd.SysProcAttr)
+		}
+		if err != nil {
+			if cgroup != nil {
+				cgroup.remove()
+			}
+			if t.stdinConn != nil {
+				t.stdinConn.Close()
+			}
+			return fmt.Errorf("setting limits for task %d: %v", t.req.TaskId, err)
+		}
+		defer cgroup.remove()
+	}
+
+	// Start under the lock, so Cancel either sees the process, or
+	// we see the cancellation.
+	t.mirror.fsMutex.Lock()
+	if t.cancelled {
+		t.mirror.fsMutex.Unlock()
+		if cgroupDir != nil {
+			cgroupDir.Close()
+		}
+		if t.stdinConn != nil {
+			t.stdinConn.Close()
+		}
+		// Not an error: the reply may carry the fate of
+		// other tasks in this file system.
+		return nil
+	}
+	t.cmd = cmd
+	t.cgroup = cgroup
+	err = cmd.Start()
+	t.mirror.fsMutex.Unlock()
+	if cgroupDir != nil {
+		cgroupDir.Close()
+	}
+	if err != nil {
 		return err
 	}
+
 	printCmd := fmt.Sprintf("%v", cmd.Args)
 	if t.req.Debug {
 		printCmd = fmt.Sprintf("%v", cmd)
 	}
 	t.taskInfo = fmt.Sprintf("%v, dir %v, fuse FS %v",
 		printCmd, cmd.Dir, state.fs.id)
+	if t.req.Timeout > 0 {
+		timer := time.AfterFunc(t.req.Timeout, func() { t.timeout(state) })
+		defer timer.Stop()
+	}
 	err = cmd.Wait()
+	t.mirror.fsMutex.Lock()
+	t.rep.TimedOut = t.timedOut
+	t.mirror.fsMutex.Unlock()
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		t.rep.Exit = exitErr.Sys().(syscall.WaitStatus)
 		err = nil
 	}
+	if err == exec.ErrWaitDelay {
+		log.Printf("Task %d left processes holding its output open", t.req.TaskId)
+		err = nil
+	}
+	if cgroup != nil {
+		t.rep.OomKilled = cgroup.oomKilled()
+	}
 
 	// No waiting: if the process exited, we kill the connection.
 	if t.stdinConn != nil {
 		t.stdinConn.Close()
 	}
+	t.closeOutput()
 
-	// We could use a connection here too, but this is simpler.
+	// Unstreamed output is returned in the reply.
 	t.rep.Stdout = stdout.String()
 	t.rep.Stderr = stderr.String()
 
 	return err
 }
 
+// How long to wait for the output of processes that a task left
+// behind, after the task itself exited.
+const outputWaitDelay = 5 * time.Second
+
+// setupTaskCmd makes the task run in its own process group, so it
+// can be killed along with its children, and keeps leftover
+// children from blocking Wait.
+func setupTaskCmd(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = outputWaitDelay
+}
+
 // fillReply empties the unionFs and hashes files as needed.  It will
 // return the FS back the pool as soon as possible.
 func (t *Mirror) fillReply(state *workerFSState) (*attr.FileSet, []string) {
@@ -0,0 +1,75 @@
+package termite
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// startedWriter signals the first write.
+type startedWriter struct {
+	buf     bytes.Buffer
+	once    sync.Once
+	started chan struct{}
+}
+
+func (w *startedWriter) Write(b []byte) (int, error) {
+	n, err := w.buf.Write(b)
+	w.once.Do(func() { close(w.started) })
+	return n, err
+}
+
+func TestWorkerTaskSignalChildren(t *testing.T) {
+	out := &startedWriter{started: make(chan struct{})}
+	cmd := exec.Command("sh", "-c", "sleep 100 & echo $!; wait")
+	setupTaskCmd(cmd)
+	cmd.Stdout = out
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	<-out.started
+
+	task := &WorkerTask{cmd: cmd}
+	task.signal(syscall.SIGKILL)
+
+	// The child holds the output pipe, so Wait only returns
+	// early if it was killed too.
+	start := time.Now()
+	cmd.Wait()
+	if dt := time.Now().Sub(start); dt >= outputWaitDelay {
+		t.Errorf("Wait took %v", dt)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(out.buf.String()))
+	if err != nil {
+		t.Fatalf("output %q: %v", out.buf.String(), err)
+	}
+	if !exited(pid) {
+		t.Errorf("child %d survived", pid)
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+}
+
+// exited returns whether the process is gone or a zombie, waiting
+// a little for it to die.
+func exited(pid int) bool {
+	for i := 0; i < 100; i++ {
+		stat, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			return true
+		}
+		// The state follows the command name in parentheses.
+		if f := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:])); len(f) > 0 && f[0] == "Z" {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
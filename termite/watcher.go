@@ -0,0 +1,213 @@
+package termite
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How often to apply changes to the attribute cache.
+const watchInterval = 200 * time.Millisecond
+
+const watchMask = syscall.IN_ATTRIB | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// fsWatcher tracks changes under a set of directories using inotify,
+// so the attribute cache can be refreshed incrementally rather than
+// rescanning all files.
+type fsWatcher struct {
+	master *Master
+	fd     int
+
+	// Serializes flushes, so a flush returns only after all
+	// changes read so far have been applied.
+	flushMutex sync.Mutex
+
+	mutex sync.Mutex
+
+	// Watched directories, without leading '/'.
+	paths map[int32]string
+	wds   map[string]int32
+
+	// Pending changes.
+	changed map[string]bool
+	subtree map[string]bool
+	full    bool
+}
+
+func newFsWatcher(m *Master, roots []string) (*fsWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &fsWatcher{
+		master:  m,
+		fd:      fd,
+		paths:   map[int32]string{},
+		wds:     map[string]int32{},
+		changed: map[string]bool{},
+		subtree: map[string]bool{},
+	}
+
+	start := time.Now()
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, r := range roots {
+		if err := w.addTree(r); err != nil {
+			syscall.Close(fd)
+			return nil, err
+		}
+	}
+	log.Printf("Watching %d directories, setup took %v", len(w.paths), time.Now().Sub(start))
+	return w, nil
+}
+
+// addTree watches dir and all directories below it. Must hold mutex.
+func (w *fsWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.IsDir() {
+			return nil
+		}
+		name := strings.TrimLeft(p, "/")
+		if w.master.excluded[name] {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, p, watchMask)
+		if err == syscall.ENOSPC {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		if err != nil {
+			return filepath.SkipDir
+		}
+		w.paths[int32(wd)] = name
+		w.wds[name] = int32(wd)
+		return nil
+	})
+}
+
+// removeTree stops watching dir and the directories below it. Must
+// hold mutex.
+func (w *fsWatcher) removeTree(dir string) {
+	for name, wd := range w.wds {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, name)
+			delete(w.paths, wd)
+		}
+	}
+}
+
+// read collects the queued events. Must hold mutex.
+func (w *fsWatcher) read() {
+	var buf [64 * (syscall.SizeofInotifyEvent + syscall.NAME_MAX + 1)]byte
+	for {
+		n, err := syscall.Read(w.fd, buf[:])
+		if err == syscall.EAGAIN {
+			return
+		}
+		if err != nil || n <= 0 {
+			log.Printf("inotify read: %v", err)
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(ev.Len)
+			name := strings.TrimRight(string(buf[start:off]), "\x00")
+			w.event(ev.Wd, ev.Mask, name)
+		}
+	}
+}
+
+// event records a single inotify event. Must hold mutex.
+func (w *fsWatcher) event(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.full = true
+		return
+	}
+	dir, ok := w.paths[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		delete(w.wds, dir)
+		return
+	}
+
+	p := dir
+	if name != "" {
+		p = filepath.Join(dir, name)
+	}
+	w.changed[p] = true
+	if mask&(syscall.IN_CREATE|syscall.IN_DELETE|syscall.IN_MOVED_FROM|syscall.IN_MOVED_TO) != 0 {
+		// The directory listing changed.
+		w.changed[dir] = true
+	}
+	if mask&syscall.IN_ISDIR == 0 {
+		return
+	}
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		if err := w.addTree("/" + p); err != nil {
+			// Changes below p may go unnoticed.
+			log.Printf("watching %q: %v", p, err)
+			w.full = true
+		}
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+		w.removeTree(p)
+		w.subtree[p] = true
+	}
+}
+
+// flush applies all changes so far to the attribute cache, and queues
+// them for the workers.
+func (w *fsWatcher) flush() {
+	w.flushMutex.Lock()
+	defer w.flushMutex.Unlock()
+
+	w.mutex.Lock()
+	w.read()
+	changed, subtree, full := w.changed, w.subtree, w.full
+	w.changed = map[string]bool{}
+	w.subtree = map[string]bool{}
+	w.full = false
+	w.mutex.Unlock()
+
+	if full {
+		log.Println("inotify queue overflowed, refreshing all attributes")
+		w.master.refreshAttributeCache()
+		return
+	}
+
+	// Directories that disappeared may have cached children.
+	for p := range subtree {
+		w.master.attributes.Queue(w.master.attributes.Refresh(p))
+	}
+	if len(changed) == 0 {
+		return
+	}
+	names := make([]string, 0, len(changed))
+	for n := range changed {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	updated := w.master.attributes.RefreshNames(names)
+	if len(updated.Files) > 0 {
+		w.master.attributes.Queue(updated)
+	}
+}
+
+func (w *fsWatcher) run() {
+	for {
+		time.Sleep(watchInterval)
+		w.flush()
+	}
+}
@@ -0,0 +1,76 @@
+package termite
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/hanwen/go-fuse/fuse"
+	"github.com/hanwen/termite/attr"
+)
+
+func TestFsWatcher(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "termite")
+	defer os.RemoveAll(dir)
+
+	getattr := func(n string) *attr.FileAttr {
+		a := &attr.FileAttr{Path: n}
+		if fi, _ := os.Lstat("/" + n); fi != nil {
+			a.Attr = fuse.ToAttr(fi)
+			a.ReadFromFs("/"+n, crypto.MD5)
+		}
+		return a
+	}
+	m := &Master{
+		excluded: map[string]bool{},
+		attributes: attr.NewAttributeCache(getattr, func(n string) *fuse.Attr {
+			fi, _ := os.Lstat("/" + n)
+			return fuse.ToAttr(fi)
+		}),
+	}
+
+	w, err := newFsWatcher(m, []string{dir})
+	if err != nil {
+		t.Fatalf("newFsWatcher: %v", err)
+	}
+	defer syscall.Close(w.fd)
+
+	name := strings.TrimLeft(dir, "/")
+	if err := ioutil.WriteFile(dir+"/file", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.flush()
+	before := m.attributes.Get(name + "/file")
+
+	if err := os.Mkdir(dir+"/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/file", []byte("bb"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.flush()
+
+	after := m.attributes.Get(name + "/file")
+	if after.Size != 2 || after.Hash == before.Hash {
+		t.Errorf("change not picked up: before %v after %v", before, after)
+	}
+	if d := m.attributes.GetDir(name); d.NameModeMap["sub"] == 0 {
+		t.Errorf("new directory not picked up: %v", d.NameModeMap)
+	}
+
+	// Changes in new directories are tracked too.
+	if err := ioutil.WriteFile(dir+"/sub/file", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m.attributes.Get(name + "/sub/file")
+	if err := os.Remove(dir + "/sub/file"); err != nil {
+		t.Fatal(err)
+	}
+	w.flush()
+	if m.attributes.Have(name + "/sub/file") {
+		t.Errorf("deletion not picked up")
+	}
+}
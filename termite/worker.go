@@ -12,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/hanwen/termite/cba"
@@ -31,6 +32,16 @@ type Worker struct {
 	accepting      bool
 	httpStatusPort int
 	mirrors        *WorkerMirrors
+
+	// For fetching content from other workers.
+	peers *contentPeers
+
+	// Finished tasks, by outcome.
+	tasks *stats.Counters
+
+	// Set while finishing the running tasks before going away.
+	drainMutex sync.Mutex
+	draining   bool
 }
 
 type User struct {
@@ -76,6 +87,15 @@ type WorkerOptions struct {
 
 	// full path to mkbox binary
 	Mkbox string
+
+	// If set, a cgroup v2 directory under which each task gets a
+	// cgroup with the limits below, overridable per task.
+	CgroupRoot string
+	Limits     ResourceLimits
+
+	// Reported to the coordinator, so masters can pick workers
+	// by label.
+	Labels Labels
 }
 
 func NewWorker(options *WorkerOptions) *Worker {
@@ -96,6 +116,12 @@ func NewWorker(options *WorkerOptions) *Worker {
 	}
 	// TODO - check that we can do renames from temp to cache.
 
+	if options.CgroupRoot != "" {
+		if err := setupCgroupRoot(options.CgroupRoot); err != nil {
+			log.Fatalf("setting up cgroups in %s: %v", options.CgroupRoot, err)
+		}
+	}
+
 	timings := stats.NewTimerStats()
 	cache := cba.NewStore(&options.StoreOptions, timings)
 
@@ -103,19 +129,28 @@ func NewWorker(options *WorkerOptions) *Worker {
 		content:        cache,
 		contentTimings: timings,
 		stats:          stats.NewServerStats(),
+		tasks:          stats.NewCounters(),
 		options:        &copied,
 		accepting:      true,
 		canRestart:     true,
 	}
 	w.stats.PhaseOrder = []string{"run", "fuse", "reap"}
 	w.mirrors = NewWorkerMirrors(w)
+	w.peers = newContentPeers(cache, newWorkerDialer(options.Secret))
 	w.stopListener = make(chan int, 1)
 	return w
 }
 
+// Content this recently used is never collected; the master may not
+// have fetched outputs of a finished task yet.
+const gcMinAge = 10 * time.Minute
+
 func (w *Worker) PeriodicHouseholding() {
 	for w.accepting {
 		w.Report()
+		if w.options.MaxSize > 0 {
+			w.content.GC(w.mirrors.liveHashes(), gcMinAge)
+		}
 		if w.options.HeapLimit > 0 {
 			heap := stats.GetMemStat().Total()
 			if heap > w.options.HeapLimit {
@@ -148,6 +183,11 @@ func (ws *WorkerService) CreateMirror(req *CreateMirrorRequest, rep *CreateMirro
 	return w.CreateMirror(req, rep)
 }
 
+func (ws *WorkerService) ServeContent(req *ContentConnectRequest, rep *ContentConnectResponse) error {
+	w := (*Worker)(ws)
+	return w.ServeContent(req, rep)
+}
+
 func (ws *WorkerService) Log(req *LogRequest, rep *LogResponse) error {
 	w := (*Worker)(ws)
 	return w.Log(req, rep)
@@ -158,6 +198,11 @@ func (ws *WorkerService) Shutdown(req *ShutdownRequest, rep *ShutdownResponse) e
 	return w.Shutdown(req, rep)
 }
 
+func (ws *WorkerService) Drain(req *DrainRequest, rep *DrainResponse) error {
+	w := (*Worker)(ws)
+	return w.Drain(req, rep)
+}
+
 func (ws *WorkerService) Status(req *WorkerStatusRequest, rep *WorkerStatusResponse) error {
 	w := (*Worker)(ws)
 	return w.Status(req, rep)
@@ -178,6 +223,9 @@ func (w *Worker) Report() {
 		Name:           fmt.Sprintf("%s:%d", Hostname, w.options.Port),
 		Version:        Version(),
 		HttpStatusPort: w.httpStatusPort,
+		Labels:         w.options.Labels,
+		Jobs:           w.options.Jobs,
+		Draining:       w.isDraining(),
 	}
 	rep := Empty{}
 	err = client.Call("Coordinator.Register", &req, &rep)
@@ -190,6 +238,9 @@ func (w *Worker) CreateMirror(req *CreateMirrorRequest, rep *CreateMirrorRespons
 	if !w.accepting {
 		return errors.New("Worker is shutting down.")
 	}
+	if w.isDraining() {
+		return ErrDraining
+	}
 	pending := w.listener.Pending()
 	rpcConn := pending.accept(req.RpcId)
 	revConn := pending.accept(req.RevRpcId)
@@ -208,6 +259,16 @@ func (w *Worker) CreateMirror(req *CreateMirrorRequest, rep *CreateMirrorRespons
 	return nil
 }
 
+// ServeContent serves the content store to another worker.
+func (w *Worker) ServeContent(req *ContentConnectRequest, rep *ContentConnectResponse) error {
+	conn := w.listener.Pending().accept(req.ContentId)
+	if conn == nil {
+		return errors.New("Worker is shutting down.")
+	}
+	go w.content.ServeConn(conn)
+	return nil
+}
+
 func (w *Worker) RunWorkerServer() {
 	listener := portRangeListener(w.options.Port, w.options.PortRetry)
 
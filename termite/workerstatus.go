@@ -49,6 +49,8 @@ func serveStatus(worker *Worker, w http.ResponseWriter, r *http.Request) {
 
 	if !status.Accepting {
 		fmt.Fprintf(w, "<b>shutting down</b>")
+	} else if status.Draining {
+		fmt.Fprintf(w, "<b>draining</b>")
 	}
 	stats.CpuStatsWriteHttp(w, status.CpuStats, status.DiskStats)
 
@@ -57,6 +59,7 @@ func serveStatus(worker *Worker, w http.ResponseWriter, r *http.Request) {
 	m := status.MemStat
 	fmt.Fprintf(w, "<p>HeapIdle: %v, HeapInUse: %v",
 		m.HeapIdle, m.HeapInuse)
+	fmt.Fprintf(w, "<p>Content cache: %v", status.ContentStats)
 
 	stats.CountStatsWriteHttp(w, status.PhaseNames, status.PhaseCounts)
 
@@ -121,6 +124,12 @@ func (w *Worker) serveStatus(port, delta int) {
 	mux.HandleFunc("/log", func(wr http.ResponseWriter, r *http.Request) {
 		serveLog(w, wr, r)
 	})
+	mux.HandleFunc("/metrics", func(wr http.ResponseWriter, r *http.Request) {
+		serveMetrics(wr, w.writeMetrics)
+	})
+	mux.HandleFunc("/api/status", func(wr http.ResponseWriter, r *http.Request) {
+		serveJSON(wr, w.apiStatus())
+	})
 
 	mux.Handle("/debug/pprof/", http.HandlerFunc(pprof.Index))
 	mux.Handle("/debug/pprof/cmdline", http.HandlerFunc(pprof.Cmdline))